@@ -0,0 +1,230 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+	"unicode"
+)
+
+// controlCommand is a request to the distributor from the keyboard or the HTTP API.
+type controlCommand uint8
+
+const (
+	controlStatus controlCommand = iota
+	controlPause
+	controlResume
+	controlStep   // Run one turn while paused
+	controlSave   // Write the current world as an image
+	controlQuit   // Stop and write the final image
+	controlWorld  // Reply with a copy of the current world
+	controlReport // Change how often alive cells are reported
+)
+
+// controlRequest asks the distributor to carry out a command between turns.
+// The distributor replies on reply if it isn't nil.
+type controlRequest struct {
+	command controlCommand
+
+	// New report settings for controlReport, as in golParams
+	reportTurns    int
+	reportInterval time.Duration
+
+	reply chan<- controlReply
+}
+
+// controlReply is the state of the game after a request was carried out.
+type controlReply struct {
+	Turn   int  `json:"turn"`
+	Alive  int  `json:"alive"`
+	Paused bool `json:"paused"`
+
+	// world is only set for controlWorld. It holds cell ages if p tracks ages.
+	world [][]byte
+	err   error
+}
+
+var errNotPaused = errors.New("the game must be paused to step")
+var errReportsDisabled = errors.New("no report sinks are enabled")
+
+// keyRequest converts a key pressed on the keyboard into a request, and reports whether the key is a command.
+func keyRequest(k rune, paused bool) (controlRequest, bool) {
+	switch unicode.ToLower(k) {
+	case 's':
+		return controlRequest{command: controlSave}, true
+	case 'p':
+		if paused {
+			return controlRequest{command: controlResume}, true
+		}
+		return controlRequest{command: controlPause}, true
+	case 'q':
+		return controlRequest{command: controlQuit}, true
+	}
+	return controlRequest{}, false
+}
+
+// controlHeader must be set, to any value, on POST requests to the HTTP API. Browsers only let a page send
+// it to the host the page came from, so other pages open on the same machine can't control the game.
+const controlHeader = "X-Game-Of-Life"
+
+// apiTimeout is how long the HTTP API waits for the distributor to take a request,
+// which it won't once the game has finished.
+const apiTimeout = 5 * time.Second
+
+// controlAPI serves the HTTP API for a running game by sending requests to the distributor:
+//
+//	GET  /status                   turn, alive cells and whether the game is paused, as JSON
+//	POST /pause, /resume, /step    pause, resume, or run one turn while paused
+//	POST /save                     write the current world as an image, as 's' does
+//	POST /quit                     stop and write the final image, as 'q' does
+//	GET  /world?format=pgm|png|rle the current world
+//	POST /report?interval=2s       report alive cells every interval, or every N turns with ?turns=N
+//
+//	GET  /                         a live view of the world with buttons to control the game
+//	GET  /live                     the WebSocket used by the live view, see serveLive
+//	GET  /metrics                  metrics of the game in the Prometheus text format
+//
+// Every endpoint except /world and the live view replies with the status after the request.
+// POST requests must set controlHeader, e.g. curl -X POST -H 'X-Game-Of-Life: 1' localhost:8080/pause.
+type controlAPI struct {
+	p        golParams
+	requests chan<- controlRequest
+	live     *liveView
+}
+
+// startControlAPI serves the HTTP API on addr in the background. It returns the channel of its requests
+// and the channel of flips for the live view, to be set as p.control and p.live, or an error if it can't
+// listen on addr.
+func startControlAPI(p golParams, addr string) (<-chan controlRequest, chan<- liveTurn, error) {
+	// Listen before starting anything, so that a busy address is reported before the game starts
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	requests := make(chan controlRequest)
+	api := controlAPI{p: p, requests: requests, live: newLiveView(p)}
+	go api.live.run()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", api.handle(controlStatus, http.MethodGet))
+	mux.HandleFunc("/pause", api.handle(controlPause, http.MethodPost))
+	mux.HandleFunc("/resume", api.handle(controlResume, http.MethodPost))
+	mux.HandleFunc("/step", api.handle(controlStep, http.MethodPost))
+	mux.HandleFunc("/save", api.handle(controlSave, http.MethodPost))
+	mux.HandleFunc("/quit", api.handle(controlQuit, http.MethodPost))
+	mux.HandleFunc("/report", api.handle(controlReport, http.MethodPost))
+	mux.HandleFunc("/world", api.world)
+	mux.HandleFunc("/live", api.serveLive)
+	mux.HandleFunc("/", api.servePage)
+	if p.metrics != nil {
+		mux.HandleFunc("/metrics", p.metrics.serveMetrics)
+	}
+
+	go func() {
+		check(http.Serve(listener, mux))
+	}()
+	return requests, api.live.turns, nil
+}
+
+// send passes req to the distributor and waits for its reply.
+func (api controlAPI) send(req controlRequest) (controlReply, error) {
+	reply := make(chan controlReply, 1)
+	req.reply = reply
+	select {
+	case api.requests <- req:
+	case <-time.After(apiTimeout):
+		return controlReply{}, errors.New("the game isn't running")
+	}
+	r := <-reply
+	return r, r.err
+}
+
+// handle returns a handler that sends the given command and replies with the status as JSON.
+func (api controlAPI) handle(command controlCommand, method string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != method {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if method == http.MethodPost && r.Header.Get(controlHeader) == "" {
+			http.Error(w, "expected the "+controlHeader+" header", http.StatusForbidden)
+			return
+		}
+
+		req := controlRequest{command: command}
+		if command == controlReport {
+			var err error
+			if s := r.FormValue("turns"); s != "" {
+				req.reportTurns, err = strconv.Atoi(s)
+			} else {
+				req.reportInterval, err = time.ParseDuration(r.FormValue("interval"))
+			}
+			if err != nil || req.reportTurns < 0 || req.reportInterval < 0 {
+				http.Error(w, "expected ?interval=<duration> or ?turns=<n>", http.StatusBadRequest)
+				return
+			}
+		}
+
+		reply, err := api.send(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(reply)
+	}
+}
+
+// world replies with the current world as a PGM, PNG or RLE.
+func (api controlAPI) world(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	format := r.FormValue("format")
+	if format == "" {
+		format = "pgm"
+	}
+	if format != "pgm" && format != "png" && format != "rle" {
+		http.Error(w, "unknown format "+strconv.Quote(format)+", expected pgm, png or rle", http.StatusBadRequest)
+		return
+	}
+
+	reply, err := api.send(controlRequest{command: controlWorld})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	// Ages are only meaningful in a PNG
+	world := reply.world
+	if api.p.tracksAge() && format != "png" {
+		for y := range world {
+			for x := range world[y] {
+				if world[y][x] != 0 {
+					world[y][x] = 0xFF
+				}
+			}
+		}
+	}
+
+	w.Header().Set("X-Turn", strconv.Itoa(reply.Turn))
+	switch format {
+	case "pgm":
+		w.Header().Set("Content-Type", "image/x-portable-graymap")
+		err = encodePgm(api.p, w, world)
+	case "png":
+		w.Header().Set("Content-Type", "image/png")
+		err = encodePng(api.p, w, world)
+	case "rle":
+		w.Header().Set("Content-Type", "text/plain")
+		_, err = w.Write([]byte(encodeRLE(world, api.p.rule)))
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
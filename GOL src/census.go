@@ -0,0 +1,428 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// censusMaxPeriod is the number of generations an unknown object is evolved for when classifying it.
+const censusMaxPeriod = 64
+
+// censusMaxPopulation stops the classification of objects that keep growing.
+const censusMaxPopulation = 1024
+
+// knownObject is a named object, given as one of its phases in rows of '.' and 'O'.
+type knownObject struct {
+	name string
+	rows string
+}
+
+// knownObjects lists the objects recognised by the census. Every phase and orientation of each is recognised.
+var knownObjects = []knownObject{
+	{"block", "OO/OO"},
+	{"beehive", ".OO./O..O/.OO."},
+	{"loaf", ".OO./O..O/.O.O/..O."},
+	{"boat", "OO./O.O/.O."},
+	{"ship", "OO./O.O/.OO"},
+	{"tub", ".O./O.O/.O."},
+	{"pond", ".OO./O..O/O..O/.OO."},
+	{"long boat", "OO../O.O./.O.O/..O."},
+	{"barge", ".O../O.O./.O.O/..O."},
+	{"eater 1", "OO../O.O./..O./..OO"},
+	{"blinker", "OOO"},
+	{"toad", ".OOO/OOO."},
+	{"beacon", "OO../OO../..OO/..OO"},
+	{"pulsar", "..OOO...OOO../............./O....O.O....O/O....O.O....O/O....O.O....O/..OOO...OOO../" +
+		"............./..OOO...OOO../O....O.O....O/O....O.O....O/O....O.O....O/............./..OOO...OOO.."},
+	{"pentadecathlon", "..O....O../OO.OOOO.OO/..O....O.."},
+	{"glider", ".O./..O/OOO"},
+	{"LWSS", ".O..O/O..../O...O/OOOO."},
+	{"MWSS", "...O../.O...O/O...../O....O/OOOOO."},
+	{"HWSS", "...OO../.O....O/O....../O.....O/OOOOOO."},
+}
+
+// knownCanonical maps the canonical form of every phase of every known object to its name.
+var knownCanonical = func() map[string]string {
+	known := make(map[string]string)
+	for _, k := range knownObjects {
+		cells := rowsToCells(k.rows)
+		for gen := 0; gen < censusMaxPeriod; gen++ {
+			c := canonical(cells)
+			if _, seen := known[c]; seen {
+				break
+			}
+			known[c] = k.name
+			cells = evolveRule(cells, conway)
+		}
+	}
+	return known
+}()
+
+// rowsToCells converts rows of '.' and 'O' separated by '/' into cells.
+func rowsToCells(rows string) []cell {
+	var cells []cell
+	for y, row := range strings.Split(rows, "/") {
+		for x, ch := range row {
+			if ch == 'O' {
+				cells = append(cells, cell{x, y})
+			}
+		}
+	}
+	return cells
+}
+
+// normalise translates cells so that their bounding box starts at 0,0 and sorts them.
+// It returns the translated cells along with the original top left corner.
+func normalise(cells []cell) ([]cell, cell) {
+	if len(cells) == 0 {
+		return nil, cell{}
+	}
+	min := cells[0]
+	for _, c := range cells {
+		if c.x < min.x {
+			min.x = c.x
+		}
+		if c.y < min.y {
+			min.y = c.y
+		}
+	}
+	out := make([]cell, len(cells))
+	for i, c := range cells {
+		out[i] = cell{c.x - min.x, c.y - min.y}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].y != out[j].y {
+			return out[i].y < out[j].y
+		}
+		return out[i].x < out[j].x
+	})
+	return out, min
+}
+
+// shape returns a string that is equal for two sets of cells if one is a translation of the other.
+func shape(cells []cell) string {
+	n, _ := normalise(cells)
+	var b strings.Builder
+	for _, c := range n {
+		b.WriteString(strconv.Itoa(c.x))
+		b.WriteByte(',')
+		b.WriteString(strconv.Itoa(c.y))
+		b.WriteByte(';')
+	}
+	return b.String()
+}
+
+// transform applies one of the 8 rotations and reflections of the square to c.
+func (c cell) transform(t int) cell {
+	x, y := c.x, c.y
+	if t&4 != 0 {
+		x = -x
+	}
+	for i := 0; i < t&3; i++ {
+		x, y = -y, x
+	}
+	return cell{x, y}
+}
+
+// canonical returns a string that is equal for two sets of cells if one can be rotated, reflected
+// and translated onto the other.
+func canonical(cells []cell) string {
+	best := ""
+	transformed := make([]cell, len(cells))
+	for t := 0; t < 8; t++ {
+		for i, c := range cells {
+			transformed[i] = c.transform(t)
+		}
+		s := shape(transformed)
+		if t == 0 || s < best {
+			best = s
+		}
+	}
+	return best
+}
+
+// evolveRule returns the next generation of cells on an infinite plane under the given rule.
+// Rules with B0 would fill the plane, so births with no neighbours are ignored.
+func evolveRule(cells []cell, r rule) []cell {
+	alive := make(map[cell]bool, len(cells))
+	neighbours := make(map[cell]int, len(cells)*8)
+	for _, c := range cells {
+		alive[c] = true
+		for i := -1; i < 2; i++ {
+			for j := -1; j < 2; j++ {
+				if i != 0 || j != 0 {
+					neighbours[cell{c.x + j, c.y + i}]++
+				}
+			}
+		}
+	}
+
+	var next []cell
+	for c, n := range neighbours {
+		if (alive[c] && r.survives(n)) || (!alive[c] && r.born(n)) {
+			next = append(next, c)
+		}
+	}
+	// Alive cells with no alive neighbours aren't in neighbours
+	for _, c := range cells {
+		if neighbours[c] == 0 && r.survives(0) {
+			next = append(next, c)
+		}
+	}
+	return next
+}
+
+// settle evolves an object in isolation under the given rule until it has the shape it started with.
+// It returns the number of generations that took, and whether the object moved, or 0 if it didn't happen
+// within censusMaxPeriod generations.
+func settle(cells []cell, r rule) (int, bool) {
+	start, origin := normalise(cells)
+	startShape := shape(start)
+
+	current := cells
+	for gen := 1; gen <= censusMaxPeriod; gen++ {
+		current = evolveRule(current, r)
+		if len(current) == 0 || len(current) > censusMaxPopulation {
+			break
+		}
+		n, corner := normalise(current)
+		if shape(n) == startShape {
+			return gen, corner != origin
+		}
+	}
+	return 0, false
+}
+
+// classify evolves an object in isolation under the given rule and returns an apgsearch style code for it:
+// xs<population> for still lifes, xp<period> for oscillators, xq<period> for spaceships and
+// zz if it didn't settle within censusMaxPeriod generations.
+func classify(cells []cell, r rule) string {
+	period, moved := settle(cells, r)
+	switch {
+	case period == 0:
+		return "zz"
+	case moved:
+		return "xq" + strconv.Itoa(period)
+	case period == 1:
+		return "xs" + strconv.Itoa(len(cells))
+	default:
+		return "xp" + strconv.Itoa(period)
+	}
+}
+
+// knownName returns the name of the known object with the shape of cells, or "" if there isn't one.
+// Objects are only named under Conway's rule, as the same shapes are different objects under other rules.
+func knownName(cells []cell, r rule) string {
+	if r != conway {
+		return ""
+	}
+	return knownCanonical[canonical(cells)]
+}
+
+// clusters splits the alive cells of a world with the given size and topology into objects.
+// Cells belong to the same object if they are within 2 cells of each other, which keeps
+// oscillators such as the toad and pulsar together in every phase.
+// Objects that cross an edge of the world that wraps are returned with coordinates outside the world
+// so that they are contiguous. Cells on opposite edges that don't wrap are never in the same object.
+func clusters(alive []cell, width, height int, topology topology) [][]cell {
+	index := make(map[cell]bool, len(alive))
+	for _, c := range alive {
+		index[c] = true
+	}
+	visited := make(map[cell]bool, len(alive))
+
+	var objects [][]cell
+	for _, start := range alive {
+		if visited[start] {
+			continue
+		}
+		visited[start] = true
+
+		// Flood fill, keeping unwrapped coordinates alongside the cells in the world
+		var object []cell
+		queue := []cell{start}
+		for len(queue) > 0 {
+			c := queue[0]
+			queue = queue[1:]
+			object = append(object, c)
+			for i := -2; i < 3; i++ {
+				for j := -2; j < 3; j++ {
+					n := cell{c.x + j, c.y + i}
+					wrapped := topology.wrap(n, width, height)
+					if index[wrapped] && !visited[wrapped] {
+						visited[wrapped] = true
+						queue = append(queue, n)
+					}
+				}
+			}
+		}
+		objects = append(objects, object)
+	}
+	return objects
+}
+
+// touching splits cells into groups of cells that touch each other, including diagonally.
+// The cells are those of an object from clusters, which are contiguous on any topology, so nothing wraps.
+func touching(cells []cell) [][]cell {
+	index := make(map[cell]bool, len(cells))
+	for _, c := range cells {
+		index[c] = true
+	}
+	visited := make(map[cell]bool, len(cells))
+
+	var groups [][]cell
+	for _, start := range cells {
+		if visited[start] {
+			continue
+		}
+		visited[start] = true
+
+		var group []cell
+		queue := []cell{start}
+		for len(queue) > 0 {
+			c := queue[0]
+			queue = queue[1:]
+			group = append(group, c)
+			for i := -1; i < 2; i++ {
+				for j := -1; j < 2; j++ {
+					n := cell{c.x + j, c.y + i}
+					if index[n] && !visited[n] {
+						visited[n] = true
+						queue = append(queue, n)
+					}
+				}
+			}
+		}
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// interact reports whether two groups of cells evolve differently together than apart within gens generations.
+func interact(a, b []cell, gens int, r rule) bool {
+	together := append(append([]cell(nil), a...), b...)
+	for gen := 0; gen < gens; gen++ {
+		a, b, together = evolveRule(a, r), evolveRule(b, r), evolveRule(together, r)
+		if len(together) != len(a)+len(b) {
+			return true
+		}
+		index := make(map[cell]bool, len(together))
+		for _, c := range together {
+			index[c] = true
+		}
+		for _, c := range append(a, b...) {
+			if !index[c] {
+				return true
+			}
+		}
+		if len(together) > censusMaxPopulation {
+			break
+		}
+	}
+	return false
+}
+
+// separate splits an object found by clusters into the objects within it that evolve independently,
+// so that objects that are close together but don't interact, such as two blocks a cell apart, are counted
+// separately. The object is split into groups of touching cells, and two groups are merged back together
+// if they interact, until every pair of groups left is independent.
+func separate(object []cell, r rule) [][]cell {
+	groups := touching(object)
+
+	// period returns the period of a still life or oscillator, or 0
+	period := func(cells []cell) int {
+		p, moved := settle(cells, r)
+		if moved {
+			return 0
+		}
+		return p
+	}
+	periods := make([]int, len(groups))
+	for i := range groups {
+		periods[i] = period(groups[i])
+	}
+
+	for i := 0; i < len(groups); i++ {
+		for j := i + 1; j < len(groups); j++ {
+			// Still lifes and oscillators are back where they started together once both have been through
+			// their periods, so they can only interact before then
+			gens := censusMaxPeriod
+			if periods[i] > 0 && periods[j] > 0 {
+				gens = periods[i] / gcd(periods[i], periods[j]) * periods[j]
+			}
+			if !interact(groups[i], groups[j], gens, r) {
+				continue
+			}
+
+			groups[i] = append(groups[i], groups[j]...)
+			periods[i] = period(groups[i])
+			groups = append(groups[:j], groups[j+1:]...)
+			periods = append(periods[:j], periods[j+1:]...)
+			// Check the merged group against every other group again
+			j = i
+		}
+	}
+	return groups
+}
+
+// censusEntry counts the objects of one kind found by a census.
+type censusEntry struct {
+	code  string
+	name  string
+	count int
+}
+
+// takeCensus segments the alive cells of a world of the size and topology given in p into objects and counts
+// each kind of object, evolving them under the rule of p. Entries are sorted with the most common first.
+func takeCensus(p golParams, alive []cell) []censusEntry {
+	counts := make(map[string]*censusEntry)
+	for _, cluster := range clusters(alive, p.imageWidth, p.imageHeight, p.topology) {
+		for _, object := range separate(cluster, p.rule) {
+			name := knownName(object, p.rule)
+			code := classify(object, p.rule)
+			key := code + "_" + name
+			if name == "" {
+				// Unknown objects of the same class are only counted together if they have the same canonical form
+				key = code + "_" + canonical(object)
+			}
+			if counts[key] == nil {
+				counts[key] = &censusEntry{code: code, name: name}
+			}
+			counts[key].count++
+		}
+	}
+
+	entries := make([]censusEntry, 0, len(counts))
+	for _, e := range counts {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].count != entries[j].count {
+			return entries[i].count > entries[j].count
+		}
+		if entries[i].code != entries[j].code {
+			return entries[i].code < entries[j].code
+		}
+		return entries[i].name < entries[j].name
+	})
+	return entries
+}
+
+// printCensus writes a census table in the style of apgsearch.
+//noinspection GoUnhandledErrorResult
+func printCensus(out io.Writer, entries []censusEntry) {
+	w := tabwriter.NewWriter(out, 0, 0, 3, ' ', 0)
+	defer w.Flush()
+	fmt.Fprintln(w, "Count\tCode\tObject")
+	for _, e := range entries {
+		name := e.name
+		if name == "" {
+			name = "unknown"
+		}
+		fmt.Fprintln(w, strconv.Itoa(e.count)+"\t"+e.code+"\t"+name)
+	}
+}
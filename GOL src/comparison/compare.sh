@@ -19,9 +19,9 @@ benchtime=10x
 for b in 128x128x2 128x128x4 128x128x8
 do
     echo ${b} on your solution
-    \time -f '%P' -o your-time.txt -a ./gameoflife.test -test.run XXX -test.bench /${b} -test.benchtime ${benchtime} >> your-out.txt
+    \time -f '%P' -o your-time.txt -a ./gameoflife.test -test.run XXX -test.bench "^Benchmark$/${b}" -test.benchtime ${benchtime} >> your-out.txt
     echo ${b} on baseline solution
-    \time -f '%P' -o base-time.txt -a ./baseline.test -test.run XXX -test.bench /${b} -test.benchtime ${benchtime} >> base-out.txt
+    \time -f '%P' -o base-time.txt -a ./baseline.test -test.run XXX -test.bench "^Benchmark$/${b}" -test.benchtime ${benchtime} >> base-out.txt
 done
 
 go build comparison/compare.go
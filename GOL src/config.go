@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+)
+
+// loadConfig sets flags from a JSON config file that maps flag names to values, for example:
+//
+//	{
+//		"headless": true,
+//		"t": 4,
+//		"w": 64,
+//		"h": 64,
+//		"turns": 1000,
+//		"rule": "B36/S23",
+//		"snapshot-every": "30s"
+//	}
+//
+// Flags that were given on the command line are left alone, so they override the file.
+func loadConfig(fs *flag.FlagSet, filename string) error {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	// Keep numbers as they were written, so that large turn counts aren't printed as 1e+06
+	var values map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err = d.Decode(&values); err != nil {
+		return fmt.Errorf("%s: %v", filename, err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for name, value := range values {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("%s: unknown flag %q", filename, name)
+		}
+		if set[name] {
+			continue
+		}
+		if err = fs.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("%s: %s: %v", filename, name, err)
+		}
+	}
+	return nil
+}
@@ -3,6 +3,9 @@ package main
 import (
 	"fmt"
 	"github.com/nsf/termbox-go"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 // getKeyboardCommand sends all keys pressed on the keyboard as runes (characters) on the key chan.
@@ -25,11 +28,25 @@ func startControlServer(p golParams) {
 	e := termbox.Init()
 	check(e)
 
+	printParams(p)
+}
+
+// printParams prints basic information about the game configuration.
+func printParams(p golParams) {
 	fmt.Println("Threads:", p.threads)
 	fmt.Println("Width:", p.imageWidth)
 	fmt.Println("Height:", p.imageHeight)
 }
 
+// quitOnInterrupt sends 'q' on the key chan when the program is interrupted or terminated,
+// so that a headless game writes its final image before exiting.
+func quitOnInterrupt(key chan<- rune) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	<-signals
+	key <- 'q'
+}
+
 // stopControlServer closes termbox.
 // If the program is terminated without closing termbox the terminal window may misbehave.
 func StopControlServer() {
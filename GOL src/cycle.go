@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/binary"
+	"hash/fnv"
+)
+
+// defaultMaxPeriod is the longest period looked for when golParams doesn't set maxPeriod.
+const defaultMaxPeriod = 1024
+
+// cycle describes a world that has started repeating itself.
+// turn is the first turn of the repeating sequence and period is its length, 1 for a still world.
+type cycle struct {
+	turn   int
+	period int
+}
+
+// hashStrip returns a hash of every cell in a worker's strip.
+func hashStrip(source [][]byte) uint64 {
+	h := fnv.New64a()
+	for _, row := range source {
+		_, _ = h.Write(row)
+	}
+	return h.Sum64()
+}
+
+// combineHashes combines the strip hashes of every worker, from the top of the world downwards, into a world hash.
+func combineHashes(strips []stripStats) uint64 {
+	h := fnv.New64a()
+	b := make([]byte, 8)
+	for _, s := range strips {
+		binary.LittleEndian.PutUint64(b, s.hash)
+		_, _ = h.Write(b)
+	}
+	return h.Sum64()
+}
+
+// cycleDetector remembers the world hashes of the last maxPeriod turns.
+type cycleDetector struct {
+	maxPeriod int
+	seen      map[uint64]int
+	history   []uint64
+}
+
+func newCycleDetector(maxPeriod int) *cycleDetector {
+	if maxPeriod <= 0 {
+		maxPeriod = defaultMaxPeriod
+	}
+	return &cycleDetector{
+		maxPeriod: maxPeriod,
+		seen:      make(map[uint64]int),
+		history:   make([]uint64, maxPeriod+1),
+	}
+}
+
+// add records the world hash after the given turn.
+// It returns true, along with the cycle, if the same world was seen within the last maxPeriod turns.
+// Turns must be added in order, starting from 0.
+func (d *cycleDetector) add(turn int, hash uint64) (cycle, bool) {
+	// The slot being overwritten holds the hash from maxPeriod+1 turns ago, which is now too old.
+	slot := turn % len(d.history)
+	if turn >= len(d.history) {
+		old := d.history[slot]
+		if d.seen[old] == turn-len(d.history) {
+			delete(d.seen, old)
+		}
+	}
+
+	first, found := d.seen[hash]
+	d.history[slot] = hash
+	d.seen[hash] = turn
+
+	if found {
+		return cycle{turn: first, period: turn - first}, true
+	}
+	return cycle{}, false
+}
@@ -2,33 +2,23 @@ package main
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
+	"os"
 	"sync"
 	"time"
-	"unicode"
 )
 
-// Read = ioInput, Write = ioOutput
-func readOrWritePgm(c ioCommand, p golParams, d distributorChans, world [][]byte, turns int) {
+// Read = ioInput, Generate = ioGenerate
+// Images are written by handing an ioImage to the io goroutine instead.
+func readOrGeneratePgm(c ioCommand, p golParams, d distributorChans) {
 	switch c {
 	// Request the io goroutine to read in the image with the given filename.
 	case ioInput:
 		d.io.command <- c
-		d.io.filename <- strings.Join([]string{strconv.Itoa(p.imageWidth), strconv.Itoa(p.imageHeight)}, "x")
+		d.io.filename <- p.input()
 
-	// Request the io goroutine to write image with given filename.
-	case ioOutput:
+	// Request the io goroutine to generate the soup or empty world described by p.
+	case ioGenerate:
 		d.io.command <- c
-		d.io.filename <- strings.Join([]string{strconv.Itoa(p.imageWidth), strconv.Itoa(p.imageHeight), strconv.Itoa(turns)}, "x")
-
-		// Send the finished state of the world to writePgmImage function
-		// TODO: Change this to non memshare version
-		for y := 0; y < p.imageHeight; y++ {
-			for x := 0; x < p.imageWidth; x++ {
-				d.io.worldState <- world[y][x]
-			}
-		}
 	}
 }
 
@@ -42,7 +32,8 @@ func worldToSourceData(world [][]byte, p golParams, startY, endY int, c chan<- b
 	}
 }
 
-func sourceToWorldData(world [][]byte, p golParams, startY, endY int, c <-chan byte, wg *sync.WaitGroup) {
+// sourceToWorldData receives a strip from a worker, followed by the ages of its cells if p tracks ages.
+func sourceToWorldData(world, ages [][]byte, p golParams, startY, endY int, c <-chan byte, wg *sync.WaitGroup) {
 	defer wg.Done()
 
 	for y := startY; y < endY; y++ {
@@ -50,17 +41,32 @@ func sourceToWorldData(world [][]byte, p golParams, startY, endY int, c <-chan b
 			world[y][x] = <-c
 		}
 	}
+	if !p.tracksAge() {
+		return
+	}
+	for y := startY; y < endY; y++ {
+		for x := 0; x < p.imageWidth; x++ {
+			ages[y][x] = <-c
+		}
+	}
 }
 
-func worker(p golParams, c chan byte, size int, sendFirst bool,
-	signalWork, signalFinish, signalComplete, state, pause, tick chan struct{}, aliveNum chan int,
-	aboveSend, belowSend chan<- byte, belowReceive, aboveReceive <-chan byte) {
+// worker owns the rows offsetY to offsetY+size of the world and applies the rule to them every turn.
+// signalWork starts a batch of n turns, run with n rows of halo from the workers above and below.
+// The strip is reported on signalFinish after each turn of the batch.
+// If p.freeRun is set, the batch is the one started through limit instead, run with a row of halo exchanged
+// every turn, and limit may end it early.
+func worker(p golParams, c chan byte, offsetY, size int,
+	signalWork <-chan int, limit *freeRunLimit, signalComplete, state chan struct{}, signalFinish chan<- stripStats,
+	aboveSend, belowSend chan<- [][]byte, belowReceive, aboveReceive <-chan [][]byte, wm *workerMetrics) {
 	// Markers of which cells should be killed/resurrected
 	var marked []cell
 
-	// Create halos
-	hAbove := make([]byte, p.imageWidth)
-	hBelow := make([]byte, p.imageWidth)
+	// Turns completed by this worker
+	turns := 0
+
+	// Halos are the rows of the neighbour workers
+	var hAbove, hBelow []byte
 
 	// Create source slice
 	sourceY := size
@@ -70,148 +76,385 @@ func worker(p golParams, c chan byte, size int, sendFirst bool,
 	}
 
 	// Receive data from world
+	alive := 0
 	for y := 0; y < sourceY; y++ {
 		for x := 0; x < p.imageWidth; x++ {
 			source[y][x] = <-c
+			if source[y][x] != 0 {
+				alive++
+			}
+		}
+	}
+
+	// Cells alive at turn 0 have been alive for one turn
+	var ages [][]byte
+	if p.tracksAge() {
+		ages = make([][]byte, sourceY)
+		for y := range ages {
+			ages[y] = make([]byte, p.imageWidth)
+			for x := range ages[y] {
+				ages[y][x] = age(0, source[y][x])
+			}
+		}
+	}
+
+	// Send the strip, followed by the ages of its cells if they are tracked
+	sendStrip := func() {
+		for y := 0; y < sourceY; y++ {
+			for x := 0; x < p.imageWidth; x++ {
+				c <- source[y][x]
+			}
+		}
+		for y := range ages {
+			for x := range ages[y] {
+				c <- ages[y][x]
+			}
+		}
+	}
+
+	// finishTurn flips the marked cells of rows, in which the strip starts at row top, and reports the strip to
+	// the distributor. Rows outside the strip are halos, which are kept up to date but not reported.
+	finishTurn := func(rows [][]byte, top int, clock time.Time) time.Time {
+		// Kill/resurrect those marked then reset contents of marked
+		var s stripStats
+		for _, m := range marked {
+			rows[m.y][m.x] = rows[m.y][m.x] ^ 0xFF
+			if m.y < top || m.y >= top + sourceY {
+				continue
+			}
+			if rows[m.y][m.x] == 0xFF {
+				s.births++
+			} else {
+				s.deaths++
+			}
+			if p.live != nil {
+				s.flips = append(s.flips, cell{m.x, m.y - top + offsetY})
+			}
 		}
+		marked = marked[:0]
+
+		for y := range ages {
+			for x := range ages[y] {
+				ages[y][x] = age(ages[y][x], source[y][x])
+			}
+		}
+
+		// Report the strip's population to the distributor
+		alive += s.births - s.deaths
+		s.alive = alive
+		if p.wantsStats() {
+			s.measureStrip(source, offsetY)
+		}
+		if p.detectCycles {
+			s.hash = hashStrip(source)
+		}
+		clock = wm.computeDone(clock)
+		signalFinish <- s
+		turns++
+		return clock
+	}
+
+	// sendHalos sends copies of the top and bottom n rows, as the neighbours may read them while this strip changes.
+	// Each channel holds one message, and neighbours can't get more than a message ahead of each other,
+	// so the exchange can't deadlock.
+	sendHalos := func(n int, clock time.Time) time.Time {
+		aboveSend <- copyRows(source[:n])
+		belowSend <- copyRows(source[sourceY - n:])
+		return wm.haloDone(clock)
+	}
+
+	// exchangeTurn exchanges a row of halo with each neighbour and runs one turn.
+	exchangeTurn := func(clock time.Time) time.Time {
+		clock = sendHalos(1, clock)
+
+		// The rows between the first and last don't need the halos, so they can be computed while the
+		// neighbours send theirs
+		if p.overlap {
+			marked = stepRows(p, source, nil, nil, 1, sourceY - 1, marked)
+			clock = wm.computeDone(clock)
+		}
+
+		// Receive halos from neighbour workers
+		hAbove = (<-aboveReceive)[0]
+		hBelow = (<-belowReceive)[0]
+
+		// Cells beyond the top and bottom of the world are dead unless the world wraps around
+		if !p.topology.wrapsY() {
+			if offsetY == 0 {
+				hAbove = make([]byte, p.imageWidth)
+			}
+			if offsetY + sourceY == p.imageHeight {
+				hBelow = make([]byte, p.imageWidth)
+			}
+		}
+
+		clock = wm.haloDone(clock)
+
+		// GOL logic
+		if p.overlap {
+			marked = stepRows(p, source, hAbove, hBelow, 0, 1, marked)
+			if sourceY > 1 {
+				marked = stepRows(p, source, hAbove, hBelow, sourceY - 1, sourceY, marked)
+			}
+		} else {
+			marked = stepRows(p, source, hAbove, hBelow, 0, sourceY, marked)
+		}
+		return finishTurn(source, 0, clock)
+	}
+
+	// ghostTurns exchanges n rows of halo with each neighbour and runs n turns without exchanging halos again.
+	// Every turn the halo rows next to the neighbours go stale, so one fewer row is computed at each end,
+	// until the last turn computes just the strip.
+	ghostTurns := func(n int, clock time.Time) time.Time {
+		clock = sendHalos(n, clock)
+		above, below := <-aboveReceive, <-belowReceive
+
+		// Cells beyond the top and bottom of the world are dead unless the world wraps around,
+		// so those halos are left dead rather than computed
+		deadAbove := !p.topology.wrapsY() && offsetY == 0
+		deadBelow := !p.topology.wrapsY() && offsetY + sourceY == p.imageHeight
+		if deadAbove {
+			above = makeWorld(p.imageWidth, n)
+		}
+		if deadBelow {
+			below = makeWorld(p.imageWidth, n)
+		}
+		clock = wm.haloDone(clock)
+
+		// The strip's rows are shared with source, so source is updated with them
+		rows := append(append(above, source...), below...)
+		for turn := 1; turn <= n; turn++ {
+			startY, endY := turn, len(rows) - turn
+			if deadAbove {
+				startY = n
+			}
+			if deadBelow {
+				endY = n + sourceY
+			}
+			marked = stepRows(p, rows, nil, nil, startY, endY, marked)
+			clock = finishTurn(rows, n, clock)
+		}
+		return clock
+	}
+
+	// freeRun runs the batch the distributor started through limit, exchanging halos every turn without waiting
+	// for it. The distributor may halt the workers before the end of the batch, so limit is checked before every turn.
+	freeRun := func(clock time.Time) time.Time {
+		batch := limit.current()
+		for limit.next(batch, turns + 1) {
+			clock = exchangeTurn(clock)
+		}
+		return clock
 	}
 
 	// Loop to:
-	// If sendFirst true, this worker sends first then receives halos later
+	// Exchange halos with neighbour workers, n rows for a batch of n turns
 	// Do GOL logic
+	idle := wm.start()
 	loop: for {
 		select {
 		case <-state:
-			for y := 0; y < sourceY; y++ {
-				for x := 0; x < p.imageWidth; x++ {
-					c <- source[y][x]
-				}
-			}
-
-		case <-pause:
-			<-pause
+			sendStrip()
 
 		case <-signalComplete:
 			break loop
 
-		case <-tick:
-			a := 0
-			for y := 0; y < sourceY; y++ {
-				for x := 0; x < p.imageWidth; x++ {
-					if source[y][x] != 0 {
-						a++
-					}
-				}
+		case n := <-signalWork:
+			clock := wm.idleDone(idle)
+			switch {
+			case p.freeRun:
+				idle = freeRun(clock)
+			case n > 1:
+				idle = ghostTurns(n, clock)
+			default:
+				idle = exchangeTurn(clock)
 			}
-			aliveNum <- a
-
-		case <-signalWork:
-			switch sendFirst {
-			case true:
-				// Send halos to neighbour workers
-				for x := 0; x < p.imageWidth; x++  {
-					aboveSend <- source[0][x]
-					belowSend <- source[sourceY - 1][x]
-				}
-
-				// Receive halos from neighbour workers
-				for x := 0; x < p.imageWidth; x++  {
-					hAbove[x] = <-aboveReceive
-					hBelow[x] = <-belowReceive
-				}
-
-			case false:
-				// Receive halos from neighbour workers
-				for x := 0; x < p.imageWidth; x++  {
-					hBelow[x] = <-belowReceive
-					hAbove[x] = <-aboveReceive
-				}
+		}
+	}
 
-				// Send halos to neighbour workers
-				for x := 0; x < p.imageWidth; x++  {
-					belowSend <- source[sourceY - 1][x]
-					aboveSend <- source[0][x]
-				}
-			}
+	// Send data from source to world
+	sendStrip()
+}
 
-			// GOL logic
-			for y := 0; y < sourceY; y++ {
-				for x := 0; x < p.imageWidth; x++ {
-					AliveCellsAround := 0
-
-					// Check for how many alive cells are around the original cell (Ignore the original cell)
-					// Adding the width and then modding it by them deals with out of bound issues
-					for i := -1; i < 2; i++ {
-						for j := -1; j < 2; j++ {
-							if y + i == y && x + j == x {
-								continue
-							} else if y + i < 0  {
-								if hAbove[((x + j) + p.imageWidth) % p.imageWidth] == 0xFF {
-									AliveCellsAround++
-								}
-							} else if y + i == sourceY {
-								if hBelow[((x + j) + p.imageWidth) % p.imageWidth] == 0xFF {
-									AliveCellsAround++
-								}
-							} else if source[y + i][((x + j) + p.imageWidth) % p.imageWidth] == 0xFF {
-								AliveCellsAround++
-							}
-						}
-					}
+// stepCells applies the rule to the rows startY to endY of a strip one cell at a time,
+// and appends the cells that flip to marked. It is the cells kernel, see stepRows.
+func stepCells(p golParams, source [][]byte, hAbove, hBelow []byte, startY, endY int, marked []cell) []cell {
+	// The fields of p used for every cell are copied out of it first
+	width, rule, wrapsX := p.imageWidth, p.rule, p.topology.wrapsX()
 
-					// Cases for alive and dead original cells
-					// 'break' isn't needed for Golang switch
-					switch source[y][x] {
-					case 0xFF: // If cell alive
-						if AliveCellsAround < 2 || AliveCellsAround > 3 {
-							marked = append(marked, cell{x, y})
+	for y := startY; y < endY; y++ {
+		for x := 0; x < width; x++ {
+			AliveCellsAround := 0
+
+			// Check for how many alive cells are around the original cell (Ignore the original cell)
+			// Adding the width and then modding it by them deals with out of bound issues
+			// If the world doesn't wrap around, cells beyond the left and right edges are dead
+			for i := -1; i < 2; i++ {
+				for j := -1; j < 2; j++ {
+					nx := ((x + j) + width) % width
+					if y + i == y && x + j == x {
+						continue
+					} else if nx != x + j && !wrapsX {
+						continue
+					} else if y + i < 0  {
+						if hAbove[nx] == 0xFF {
+							AliveCellsAround++
 						}
-					case 0x00: // If cell dead
-						if AliveCellsAround == 3 {
-							marked = append(marked, cell{x, y})
+					} else if y + i == len(source) {
+						if hBelow[nx] == 0xFF {
+							AliveCellsAround++
 						}
+					} else if source[y + i][nx] == 0xFF {
+						AliveCellsAround++
 					}
 				}
 			}
 
-			// Kill/resurrect those marked then reset contents of marked
-			for _, cell := range marked {
-				source[cell.y][cell.x] = source[cell.y][cell.x] ^ 0xFF
+			// Cases for alive and dead original cells
+			// 'break' isn't needed for Golang switch
+			switch source[y][x] {
+			case 0xFF: // If cell alive
+				if !rule.survives(AliveCellsAround) {
+					marked = append(marked, cell{x, y})
+				}
+			case 0x00: // If cell dead
+				if rule.born(AliveCellsAround) {
+					marked = append(marked, cell{x, y})
+				}
 			}
-			marked = nil
-			signalFinish <- struct {}{}
 		}
 	}
+	return marked
+}
 
-	// Send data from source to world
-	for y := 0; y < sourceY; y++ {
-		for x := 0; x < p.imageWidth; x++ {
-			c <- source[y][x]
+// gatherWorld asks every worker for its strip and copies the strips into world, and their ages into ages.
+func gatherWorld(p golParams, world, ages [][]byte, yParams []int, c []chan byte, state []chan struct{}) {
+	for i := range state {
+		state[i] <- struct{}{}
+	}
+
+	var wgData sync.WaitGroup
+	wgData.Add(p.threads)
+	for t := 0; t < p.threads; t++ {
+		go sourceToWorldData(world, ages, p, yParams[t], yParams[t + 1], c[t], &wgData)
+	}
+	wgData.Wait()
+}
+
+// makeWorld returns an empty world of the given size.
+func makeWorld(width, height int) [][]byte {
+	world := make([][]byte, height)
+	for i := range world {
+		world[i] = make([]byte, width)
+	}
+	return world
+}
+
+// copyRows returns a copy of rows that doesn't share their memory.
+func copyRows(rows [][]byte) [][]byte {
+	c := make([][]byte, len(rows))
+	for y := range rows {
+		c[y] = append([]byte(nil), rows[y]...)
+	}
+	return c
+}
+
+// aliveCells returns the coordinates of every alive cell in world.
+func aliveCells(world [][]byte) []cell {
+	var alive []cell
+	// Go through the world and append the cells that are still alive.
+	for y := range world {
+		for x := range world[y] {
+			if world[y][x] != 0 {
+				alive = append(alive, cell{x: x, y: y})
+			}
 		}
 	}
+	return alive
+}
+
+// freeRunLead is the number of turns free running workers can finish before the distributor collects them.
+const freeRunLead = 16
+
+// freeRunLimit is shared by free running workers and the distributor so that it can halt them all at the same turn.
+type freeRunLimit struct {
+	sync.Mutex
+
+	// Batches are counted, so that a worker still finishing a halted batch doesn't run on into the next one
+	batch    int
+	target   int // Workers don't start turns after target
+	furthest int // The furthest turn any worker has started
+}
+
+// next reports whether a worker running the given batch may start the given turn, and records that it has.
+func (l *freeRunLimit) next(batch, turn int) bool {
+	l.Lock()
+	defer l.Unlock()
+	if batch != l.batch || turn > l.target {
+		return false
+	}
+	if turn > l.furthest {
+		l.furthest = turn
+	}
+	return true
+}
+
+// start starts a new batch running up to the target turn.
+func (l *freeRunLimit) start(target int) {
+	l.Lock()
+	l.batch++
+	l.target = target
+	l.Unlock()
+}
+
+// current returns the batch last started.
+func (l *freeRunLimit) current() int {
+	l.Lock()
+	defer l.Unlock()
+	return l.batch
+}
+
+// halt stops workers starting any turn after the furthest one already started, which every worker can still
+// reach, and returns that turn.
+func (l *freeRunLimit) halt() int {
+	l.Lock()
+	defer l.Unlock()
+	l.target = l.furthest
+	return l.target
 }
 
 // distributor divides the work between workers and interacts with other goroutines.
 func distributor(p golParams, d distributorChans, alive chan []cell, c []chan byte, yChan chan int,
-	keyChan <-chan rune, signalWork, signalFinish, signalComplete, state, pause, tick []chan struct{},
-	aliveNum []chan int) {
+	keyChan <-chan rune, signalWork []chan int, limit *freeRunLimit, signalComplete, state []chan struct{},
+	signalFinish []chan stripStats) {
 
 	// Create the 2D slice to store the world.
-	world := make([][]byte, p.imageHeight)
-	for i := range world {
-		world[i] = make([]byte, p.imageWidth)
+	world := makeWorld(p.imageWidth, p.imageHeight)
+
+	// Images show the ages of cells instead of the world if ages are tracked
+	output := world
+	var ages [][]byte
+	if p.tracksAge() {
+		ages = makeWorld(p.imageWidth, p.imageHeight)
+		output = ages
 	}
 
-	// Read pgm image
-	readOrWritePgm(ioInput, p, d, world, p.turns)
+	// Read pgm image, or generate a soup or empty world
+	if p.generated() {
+		readOrGeneratePgm(ioGenerate, p, d)
+	} else {
+		readOrGeneratePgm(ioInput, p, d)
+	}
 
 	// The io goroutine sends the requested image byte by byte, in rows.
+	aliveCount := 0
 	for y := 0; y < p.imageHeight; y++ {
 		for x := 0; x < p.imageWidth; x++ {
 			val := <-d.io.inputVal
 			if val != 0 {
-				fmt.Println("Alive cell at", x, y)
+				p.println("Alive cell at", x, y)
 				world[y][x] = val
+				aliveCount++
 			}
 		}
 	}
@@ -233,81 +476,334 @@ func distributor(p golParams, d distributorChans, alive chan []cell, c []chan by
 	// Wait until all workers have completed source
 	wgData.Wait()
 
-	turns := 0
-	ticker := time.NewTicker(2 * time.Second)
-	loop: for turns < p.turns {
+	// Report alive cells either every p.reportTurns turns or every p.reportInterval
+	r := newReporter(p)
+	defer r.close()
+	births, deaths := 0, 0
+	sendReport := func(turns int) {
+		r.send(report{turn: turns, wallclock: time.Now(), alive: aliveCount, births: births, deaths: deaths})
+		births, deaths = 0, 0
+	}
+
+	// Record population statistics for every turn
+	sr := newStatsRecorder(p)
+	defer sr.close()
+	strips := make([]stripStats, p.threads)
+
+	// Look for the world repeating itself, starting from the turn 0 world
+	var cd *cycleDetector
+	if p.detectCycles {
+		cd = newCycleDetector(p.maxPeriod)
+		for t := range strips {
+			strips[t].hash = hashStrip(world[yParams[t]:yParams[t + 1]])
+		}
+		cd.add(0, combineHashes(strips))
+	}
+
+	// Follow objects from turn to turn to find spaceships and guns
+	var tk *tracker
+	if p.track {
+		tk = newTracker(p)
+		tk.add(0, aliveCells(world))
+	}
+
+	// The live view starts from an empty world, so the turn 0 world is sent as flips
+	if p.live != nil {
+		p.live <- liveTurn{turn: 0, flips: aliveCells(world)}
+	}
+
+	// Record an animation of the run, starting from the turn 0 world
+	var rec *recorder
+	if p.record.enabled() {
+		rec = newRecorder(p)
+		rec.capture(0, world)
+	}
+
+	// Reports on a timer are restarted whenever the control API changes the report settings
+	var tickC <-chan time.Time
+	var reportTicker *time.Ticker
+	startReportTicker := func() {
+		if reportTicker != nil {
+			reportTicker.Stop()
+			reportTicker, tickC = nil, nil
+		}
+		if r.enabled() && p.reportTurns == 0 && p.reportInterval > 0 {
+			reportTicker = time.NewTicker(p.reportInterval)
+			tickC = reportTicker.C
+		}
+	}
+	startReportTicker()
+	defer func() {
+		if reportTicker != nil {
+			reportTicker.Stop()
+		}
+	}()
+
+	// Take snapshots either every p.snapshots.turns turns or every p.snapshots.interval
+	var snapshotC <-chan time.Time
+	if p.snapshots.turns == 0 && p.snapshots.interval > 0 {
+		ticker := time.NewTicker(p.snapshots.interval)
+		defer ticker.Stop()
+		snapshotC = ticker.C
+	}
+
+	// Images are copied into one of ioBuffers buffers and handed to the io goroutine, which hands the
+	// buffer back once the image is written. The distributor only waits if every buffer is being written.
+	buffers := 0
+	freeBuffer := func(wait bool) [][]byte {
 		select {
-		case k := <-keyChan:
-			switch unicode.ToLower(k) {
-			case 's':
-				for i := range state {
-					state[i] <- struct{}{}
-				}
+		case buf := <-d.io.buffers:
+			return buf
+		default:
+		}
+		if buffers < ioBuffers {
+			buffers++
+			return makeWorld(p.imageWidth, p.imageHeight)
+		}
+		if !wait {
+			return nil
+		}
+		return <-d.io.buffers
+	}
+	saveImage := func(name string, buf [][]byte, snapshot bool) {
+		for y := range output {
+			copy(buf[y], output[y])
+		}
+		d.io.images <- ioImage{name: name, world: buf, snapshot: snapshot}
+	}
 
-				wgData.Add(p.threads)
-				for t := 0; t < p.threads; t++ {
-					go sourceToWorldData(world, p, yParams[t], yParams[t + 1], c[t], &wgData)
-				}
-				wgData.Wait()
+	// Snapshots are skipped rather than waited for
+	takeSnapshot := func(turns int) {
+		buf := freeBuffer(false)
+		if buf == nil {
+			p.println("Skipped snapshot at turn", turns, "while the last ones are written")
+			return
+		}
+		gatherWorld(p, world, ages, yParams, c, state)
+		saveImage(p.snapshotName(turns), buf, true)
+	}
 
-				readOrWritePgm(ioOutput, p, d, world, turns)
+	turns := 0
+	paused, quit := false, false
 
-			case 'p':
-				fmt.Println("Paused at turn ", turns)
+	// collectTurn collects the strips of the next turn from the workers and reports whether the game should stop
+	collectTurn := func() bool {
+		waitStart := time.Now()
+		for i := range signalFinish {
+			strips[i] = <-signalFinish[i]
+		}
+		turns++
+
+		ts := combineStrips(p, turns, strips, yParams)
+		aliveCount = ts.alive
+		births += ts.births
+		deaths += ts.deaths
+		sr.record(ts)
+		if p.metrics != nil {
+			p.metrics.turnDone(turns, aliveCount, time.Since(waitStart))
+		}
 
-				for i := range pause {
-					pause[i] <- struct{}{}
-				}
+		if p.live != nil {
+			var flips []cell
+			for _, s := range strips {
+				flips = append(flips, s.flips...)
+			}
+			p.live <- liveTurn{turn: turns, flips: flips}
+		}
 
-				wgData.Add(1)
-				go func() {
-					loop: for {
-						select {
-						case k := <-keyChan:
-							switch unicode.ToLower(k) {
-							case 'p':
-								fmt.Println("Continuing...")
-								for i := range pause {
-									pause[i] <- struct{}{}
-								}
-								wgData.Done()
-								break loop
-
-							default:
-							}
-						}
+		if r.enabled() && p.reportTurns > 0 && turns % p.reportTurns == 0 {
+			sendReport(turns)
+		}
+
+		if p.snapshots.turns > 0 && turns % p.snapshots.turns == 0 {
+			takeSnapshot(turns)
+		}
+
+		// Gather the world once for the tracker and recorder
+		if tk != nil || (rec != nil && rec.wants(turns)) {
+			gatherWorld(p, world, ages, yParams, c, state)
+		}
+		if tk != nil {
+			printTracked(tk.add(turns, aliveCells(world)))
+		}
+		if rec != nil && rec.wants(turns) {
+			rec.capture(turns, world)
+		}
+
+		if cd != nil {
+			if cyc, found := cd.add(turns, combineHashes(strips)); found {
+				p.println("Stable at turn", cyc.turn, "with period", cyc.period)
+				if p.cycles != nil {
+					select {
+					case p.cycles <- cyc:
+					default:
 					}
-				}()
-				wgData.Wait()
+				}
+				cd = nil
+				if p.stopOnCycle {
+					return true
+				}
+			}
+		}
+		return false
+	}
 
-			case 'q':
-				fmt.Println("Quitting...")
-				break loop
+	// Workers have been asked to run up to target
+	target := 0
 
-			default:
+	// start has the workers run a batch of n turns
+	start := func(n int) {
+		if p.freeRun {
+			limit.start(turns + n)
+		}
+		for i := range signalWork {
+			signalWork[i] <- n
+		}
+		target = turns + n
+	}
+
+	// step runs a batch of n turns and reports whether the game should stop.
+	// The workers only exchange halos at the start of the batch, so the world can't be gathered until its end.
+	step := func(n int) bool {
+		start(n)
+		stop := false
+		for turns < target {
+			stop = collectTurn() || stop
+		}
+		return stop
+	}
+
+	// halt stops free running workers at the furthest turn any of them has started, which the others can still
+	// reach, and collects the turns up to it so that the world can be gathered.
+	halt := func() {
+		if turns == target {
+			return
+		}
+		target = limit.halt()
+		for turns < target {
+			if collectTurn() {
+				quit = true
 			}
+		}
+	}
 
-		case <-ticker.C:
-			a := 0
-			for i := range tick {
-				tick[i] <- struct {}{}
+	// batch returns how many turns to run before the world is next needed, up to the halo depth.
+	// Free running workers run until the world is needed, unless the distributor halts them sooner.
+	batch := func() int {
+		n := p.haloDepth
+		if p.freeRun || n > p.turns - turns {
+			n = p.turns - turns
+		}
+		if p.snapshots.turns > 0 && n > p.snapshots.turns - turns % p.snapshots.turns {
+			n = p.snapshots.turns - turns % p.snapshots.turns
+		}
+		// The tracker and recorder may need the world every turn, and a game that stops on a cycle
+		// stops at the turn it is found
+		if tk != nil || rec != nil || p.stopOnCycle {
+			n = 1
+		}
+		return n
+	}
+
+	// handle carries out a request from the keyboard or the control API between turns
+	handle := func(req controlRequest) {
+		var reply controlReply
+		switch req.command {
+		case controlSave, controlPause, controlQuit, controlWorld:
+			halt()
+		}
+
+		switch req.command {
+		case controlSave:
+			gatherWorld(p, world, ages, yParams, c, state)
+			saveImage(p.outputName(turns), freeBuffer(true), false)
+
+		case controlPause:
+			if !paused {
+				fmt.Println("Paused at turn ", turns)
+				paused = true
 			}
-			for i := range aliveNum {
-				a += <-aliveNum[i]
+
+		case controlResume:
+			if paused {
+				fmt.Println("Continuing...")
+				paused = false
 			}
-			fmt.Println("No. of alive cells: ", a)
 
-		default:
-			for i := range signalWork {
-				signalWork[i] <- struct {}{}
+		case controlStep:
+			if paused {
+				quit = step(1)
+			} else {
+				reply.err = errNotPaused
 			}
-			for i := range signalFinish {
-				<-signalFinish[i]
+
+		case controlQuit:
+			fmt.Println("Quitting...")
+			quit = true
+
+		case controlWorld:
+			gatherWorld(p, world, ages, yParams, c, state)
+			reply.world = makeWorld(p.imageWidth, p.imageHeight)
+			for y := range output {
+				copy(reply.world[y], output[y])
+			}
+
+		case controlReport:
+			if r.enabled() {
+				p.reportTurns, p.reportInterval = req.reportTurns, req.reportInterval
+				startReportTicker()
+			} else {
+				reply.err = errReportsDisabled
 			}
-			turns++
+		}
+
+		if req.reply != nil {
+			reply.Turn, reply.Alive, reply.Paused = turns, aliveCount, paused
+			req.reply <- reply
 		}
 	}
 
+	// ready can always be received from, so that turns run whenever nothing else is waiting unless paused
+	ready := make(chan struct{})
+	close(ready)
+
+	for !quit && turns < p.turns {
+		run := ready
+		if paused {
+			run = nil
+		}
+
+		select {
+		case k := <-keyChan:
+			if req, ok := keyRequest(k, paused); ok {
+				handle(req)
+			}
+
+		case req := <-p.control:
+			handle(req)
+
+		case <-tickC:
+			sendReport(turns)
+
+		case <-snapshotC:
+			halt()
+			takeSnapshot(turns)
+
+		case <-run:
+			switch {
+			case !p.freeRun:
+				quit = step(batch())
+			case turns == target:
+				start(batch())
+			default:
+				quit = collectTurn()
+			}
+		}
+	}
+
+	// Let free running workers stop before they are asked for the world
+	halt()
+
 	for i := range signalComplete {
 		signalComplete[i] <- struct {}{}
 	}
@@ -315,27 +811,34 @@ func distributor(p golParams, d distributorChans, alive chan []cell, c []chan by
 	// Receive data from source to world
 	wgData.Add(p.threads)
 	for t := 0; t < p.threads; t++ {
-		go sourceToWorldData(world, p, yParams[t], yParams[t + 1], c[t], &wgData)
+		go sourceToWorldData(world, ages, p, yParams[t], yParams[t + 1], c[t], &wgData)
 	}
 	wgData.Wait()
 
+	// Finish the recording with the final world
+	if rec != nil {
+		if rec.lastTurn != turns {
+			rec.capture(turns, world)
+		}
+		rec.close(p)
+	}
+
 	// Write image
-	readOrWritePgm(ioOutput, p, d, world, turns)
+	if !p.noFinalImage {
+		saveImage(p.outputName(turns), freeBuffer(true), false)
+	}
 
 	// Create an empty slice to store coordinates of cells that are still alive after p.turns are done.
-	var finalAlive []cell
-	// Go through the world and append the cells that are still alive.
-	for y := 0; y < p.imageHeight; y++ {
-		for x := 0; x < p.imageWidth; x++ {
-			if world[y][x] != 0 {
-				finalAlive = append(finalAlive, cell{x: x, y: y})
-			}
-		}
-	}
+	finalAlive := aliveCells(world)
 
 	// Make sure that the Io has finished any output before exiting.
 	d.io.command <- ioCheckIdle
 	<-d.io.idle
+	d.io.command <- ioQuit
+
+	if p.workerStats {
+		p.metrics.writeWorkerReport(os.Stdout, turns, yParams)
+	}
 
 	// Return the coordinates of cells that are still alive.
 	alive <- finalAlive
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// imageFormat is the format of the images written by the io goroutine.
+type imageFormat uint8
+
+const (
+	formatPGM imageFormat = iota // Raw P5 with alive cells as 255, the default
+	formatPNG
+)
+
+var imageFormatNames = []string{"pgm", "png"}
+
+func (f imageFormat) String() string {
+	return imageFormatNames[f]
+}
+
+// parseImageFormat converts a name such as "png" into an imageFormat.
+func parseImageFormat(name string) (imageFormat, error) {
+	for i, n := range imageFormatNames {
+		if strings.EqualFold(n, name) {
+			return imageFormat(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown image format %q, expected one of %s", name, strings.Join(imageFormatNames, ", "))
+}
+
+// gridMinScale is the smallest scale at which PNG images get gridlines between cells.
+const gridMinScale = 6
+
+// colourScheme gives the colours of a PNG image.
+// Alive cells are young, unless the age layer is drawn, in which case they fade from young to old.
+type colourScheme struct {
+	name       string
+	dead, grid color.RGBA
+	young, old color.RGBA
+}
+
+var colourSchemes = []colourScheme{
+	{"classic", rgb(0x000000), rgb(0x303030), rgb(0xffffff), rgb(0x2040ff)},
+	{"paper", rgb(0xffffff), rgb(0xd0d0d0), rgb(0x000000), rgb(0xc04000)},
+	{"heat", rgb(0x000000), rgb(0x202020), rgb(0xffff80), rgb(0x800000)},
+	{"phosphor", rgb(0x001000), rgb(0x003000), rgb(0x80ff80), rgb(0x006000)},
+}
+
+// rgb converts a colour such as 0xff8000 into a colour.
+func rgb(v uint32) color.RGBA {
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xFF}
+}
+
+// parseScheme returns the colour scheme with the given name.
+func parseScheme(name string) (colourScheme, error) {
+	var names []string
+	for _, s := range colourSchemes {
+		if strings.EqualFold(s.name, name) {
+			return s, nil
+		}
+		names = append(names, s.name)
+	}
+	return colourScheme{}, fmt.Errorf("unknown colour scheme %q, expected one of %s", name, strings.Join(names, ", "))
+}
+
+// alive returns the colour of an alive cell of the given age, fading on a log scale
+// from young at age 1 to old at maxAge.
+func (s colourScheme) alive(age byte) color.RGBA {
+	f := math.Log(float64(age)) / math.Log(maxAge)
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a) + (float64(b)-float64(a))*f + 0.5)
+	}
+	return color.RGBA{R: mix(s.young.R, s.old.R), G: mix(s.young.G, s.old.G), B: mix(s.young.B, s.old.B), A: 0xFF}
+}
+
+// maxAge is the age at which cell ages stop increasing. Ages are kept in a byte for each cell.
+const maxAge = 255
+
+// imageParams describes the images written after a run or when 's' is pressed.
+type imageParams struct {
+	format imageFormat
+	scheme colourScheme
+	scale  int // Width and height in pixels of each cell in a PNG
+
+	// With age, workers count how many turns each cell has been alive for
+	// and PNG images colour alive cells by their age.
+	age bool
+}
+
+// tracksAge reports whether workers should keep the age of every cell.
+func (p golParams) tracksAge() bool {
+	return p.image.format == formatPNG && p.image.age
+}
+
+// age returns the age of a cell after a turn, given its age before the turn and whether it is now alive.
+func age(before, alive byte) byte {
+	switch {
+	case alive == 0:
+		return 0
+	case before == maxAge:
+		return maxAge
+	default:
+		return before + 1
+	}
+}
+
+// writePng writes world to <filename>.png in the output directory and returns the path written.
+// The bytes of world are the ages of cells if p tracks ages, and 0 or 255 otherwise.
+func writePng(p golParams, filename string, world [][]byte) string {
+	_ = os.MkdirAll(p.outDir(), os.ModePerm)
+
+	path := filepath.Join(p.outDir(), filename+".png")
+	file, ioError := os.Create(path)
+	check(ioError)
+	defer file.Close()
+
+	check(encodePng(p, p.metrics.countWrites(file), world))
+	check(file.Sync())
+
+	p.println("File", filename, "output done!")
+	return path
+}
+
+// encodePng writes world to w as a PNG image in the colours selected by p.
+func encodePng(p golParams, w io.Writer, world [][]byte) error {
+	scheme := p.image.scheme
+	if scheme.name == "" {
+		scheme = colourSchemes[0]
+	}
+	scale := p.image.scale
+	if scale <= 0 {
+		scale = 1
+	}
+	grid := scale >= gridMinScale
+
+	img := image.NewRGBA(image.Rect(0, 0, p.imageWidth*scale, p.imageHeight*scale))
+	for y := 0; y < p.imageHeight; y++ {
+		for x := 0; x < p.imageWidth; x++ {
+			v := world[y][x]
+			colour := scheme.dead
+			if v != 0 && p.tracksAge() {
+				colour = scheme.alive(v)
+			} else if v != 0 {
+				colour = scheme.young
+			}
+
+			for i := 0; i < scale; i++ {
+				for j := 0; j < scale; j++ {
+					if grid && (i == 0 || j == 0) {
+						img.SetRGBA(x*scale+j, y*scale+i, scheme.grid)
+					} else {
+						img.SetRGBA(x*scale+j, y*scale+i, colour)
+					}
+				}
+			}
+		}
+	}
+
+	return png.Encode(w, img)
+}
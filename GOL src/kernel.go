@@ -0,0 +1,251 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// kernel is the way workers apply the rule to their strips.
+type kernel uint8
+
+const (
+	kernelCells  kernel = iota // Count the neighbours of each cell
+	kernelLUT                  // Look up the cells that flip in 2x2 blocks from their 4x4 neighbourhoods
+	kernelRowSum               // Slide a window along sums of the columns of three rows
+)
+
+var kernelNames = []string{"cells", "lut", "rowsum"}
+
+func (k kernel) String() string {
+	return kernelNames[k]
+}
+
+// parseKernel converts a name such as "lut" into a kernel.
+func parseKernel(name string) (kernel, error) {
+	for i, n := range kernelNames {
+		if strings.EqualFold(n, name) {
+			return kernel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown kernel %q, expected one of %s", name, strings.Join(kernelNames, ", "))
+}
+
+// stepRows applies the rule to the rows startY to endY of a strip with the kernel chosen in p,
+// and appends the cells that flip to marked. hAbove and hBelow are the rows either side of the strip,
+// and are only read for its first and last rows.
+func stepRows(p golParams, source [][]byte, hAbove, hBelow []byte, startY, endY int, marked []cell) []cell {
+	switch p.kernel {
+	case kernelLUT:
+		return stepBlocks(p, source, hAbove, hBelow, startY, endY, marked)
+	case kernelRowSum:
+		return stepRowSums(p, source, hAbove, hBelow, startY, endY, marked)
+	default:
+		return stepCells(p, source, hAbove, hBelow, startY, endY, marked)
+	}
+}
+
+// stripRow returns row y of a strip, or the halo above or below it.
+func stripRow(source [][]byte, hAbove, hBelow []byte, y int) []byte {
+	switch y {
+	case -1:
+		return hAbove
+	case len(source):
+		return hBelow
+	}
+	return source[y]
+}
+
+// blockTable holds the cells of a 2x2 block that flip for every 4x4 neighbourhood around the block.
+// A neighbourhood is indexed by its rows from the top, four bits each, with the leftmost cell of a row in its
+// most significant bit. Bit 0 of an entry is the top left cell of the block, bit 1 the top right,
+// bit 2 the bottom left and bit 3 the bottom right.
+type blockTable [1 << 16]uint8
+
+// blockTables caches the table of every rule used, as generating one takes a few milliseconds.
+var blockTables = struct {
+	sync.Mutex
+	tables map[rule]*blockTable
+}{tables: make(map[rule]*blockTable)}
+
+// blockTable returns the lookup table of r for the lut kernel, generating it the first time it is needed.
+func (r rule) blockTable() *blockTable {
+	blockTables.Lock()
+	defer blockTables.Unlock()
+	t, ok := blockTables.tables[r]
+	if !ok {
+		t = r.generateBlockTable()
+		blockTables.tables[r] = t
+	}
+	return t
+}
+
+func (r rule) generateBlockTable() *blockTable {
+	t := new(blockTable)
+	for i := range t {
+		// alive reports whether the cell in row y and column x of the neighbourhood is alive
+		alive := func(y, x int) bool {
+			return i>>uint(15-4*y-x)&1 != 0
+		}
+		for b := 0; b < 4; b++ {
+			y, x := 1+b/2, 1+b%2
+			n := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if (dy != 0 || dx != 0) && alive(y+dy, x+dx) {
+						n++
+					}
+				}
+			}
+			if alive(y, x) && !r.survives(n) || !alive(y, x) && r.born(n) {
+				t[i] |= 1 << uint(b)
+			}
+		}
+	}
+	return t
+}
+
+// stepBlocks applies the rule to the rows startY to endY of a strip two rows and two columns at a time,
+// looking up the cells that flip in each 2x2 block from the 4x4 neighbourhood around it. It is the lut kernel.
+// The neighbourhood slides along each pair of rows, so each cell is read once per pair of rows.
+// A row or column left over when there is an odd number of them is done a cell at a time.
+func stepBlocks(p golParams, source [][]byte, hAbove, hBelow []byte, startY, endY int, marked []cell) []cell {
+	table := p.rule.blockTable()
+	width, wrapsX := p.imageWidth, p.topology.wrapsX()
+
+	// at returns 1 if the cell in column x of r is alive, wrapping around the world if it does
+	at := func(r []byte, x int) int {
+		if x < 0 || x >= width {
+			if !wrapsX {
+				return 0
+			}
+			x = (x + width) % width
+		}
+		return int(r[x] & 1)
+	}
+
+	for y := startY; y+1 < endY; y += 2 {
+		rows := [4][]byte{
+			stripRow(source, hAbove, hBelow, y-1),
+			stripRow(source, hAbove, hBelow, y),
+			stripRow(source, hAbove, hBelow, y+1),
+			stripRow(source, hAbove, hBelow, y+2),
+		}
+
+		// The columns x-1 to x+2 of each row
+		var window [4]int
+		for i, r := range rows {
+			window[i] = at(r, -1)<<3 | at(r, 0)<<2 | at(r, 1)<<1 | at(r, 2)
+		}
+
+		for x := 0; x+1 < width; x += 2 {
+			if flips := table[window[0]<<12|window[1]<<8|window[2]<<4|window[3]]; flips != 0 {
+				if flips&1 != 0 {
+					marked = append(marked, cell{x, y})
+				}
+				if flips&2 != 0 {
+					marked = append(marked, cell{x + 1, y})
+				}
+				if flips&4 != 0 {
+					marked = append(marked, cell{x, y + 1})
+				}
+				if flips&8 != 0 {
+					marked = append(marked, cell{x + 1, y + 1})
+				}
+			}
+
+			// Slide the window two columns to the right, only checking the edges near the end of the rows
+			if x+4 < width {
+				for i, r := range rows {
+					window[i] = (window[i]<<2 | int(r[x+3]&1)<<1 | int(r[x+4]&1)) & 0xF
+				}
+			} else {
+				for i, r := range rows {
+					window[i] = (window[i]<<2 | at(r, x+3)<<1 | at(r, x+4)) & 0xF
+				}
+			}
+		}
+
+		if width%2 == 1 {
+			marked = stepEdgeCell(source, hAbove, hBelow, width, p.rule, wrapsX, width-1, y, marked)
+			marked = stepEdgeCell(source, hAbove, hBelow, width, p.rule, wrapsX, width-1, y+1, marked)
+		}
+	}
+
+	if (endY-startY)%2 == 1 {
+		marked = stepCells(p, source, hAbove, hBelow, endY-1, endY, marked)
+	}
+	return marked
+}
+
+// stepRowSums applies the rule to the rows startY to endY of a strip by adding up each column of the row
+// and the rows either side, then sliding a window of three column sums along the row. It is the rowsum kernel.
+// The sums have an extra column at each end for the columns beyond the edges, so no cell needs a modulo.
+func stepRowSums(p golParams, source [][]byte, hAbove, hBelow []byte, startY, endY int, marked []cell) []cell {
+	width := p.imageWidth
+
+	// flips[n] is set if a dead cell with n alive neighbours is born, and flips[9+n] if an alive one dies
+	var flips [18]bool
+	for n := 0; n <= 8; n++ {
+		flips[n] = p.rule.born(n)
+		flips[9+n] = !p.rule.survives(n)
+	}
+
+	// sums[x+1] is the number of alive cells in column x of the three rows
+	sums := make([]uint8, width+2)
+	for y := startY; y < endY; y++ {
+		above := stripRow(source, hAbove, hBelow, y-1)
+		middle := stripRow(source, hAbove, hBelow, y)
+		below := stripRow(source, hAbove, hBelow, y+1)
+		for x := 0; x < width; x++ {
+			sums[x+1] = above[x]&1 + middle[x]&1 + below[x]&1
+		}
+		// Cells beyond the left and right edges are dead unless the world wraps around
+		if p.topology.wrapsX() {
+			sums[0], sums[width+1] = sums[width], sums[1]
+		}
+
+		window := int(sums[0]) + int(sums[1]) + int(sums[2])
+		for x := 0; x < width; x++ {
+			if x > 0 {
+				window += int(sums[x+2]) - int(sums[x-1])
+			}
+			alive := int(middle[x] & 1)
+			if flips[9*alive+window-alive] {
+				marked = append(marked, cell{x, y})
+			}
+		}
+	}
+	return marked
+}
+
+// stepEdgeCell applies the rule r to the cell at x, y of a strip width cells wide, and appends it to marked if it
+// flips. It is used for the column stepBlocks leaves over when the world has an odd width.
+func stepEdgeCell(source [][]byte, hAbove, hBelow []byte, width int, r rule, wrapsX bool, x, y int,
+	marked []cell) []cell {
+	n := 0
+	for i := -1; i < 2; i++ {
+		row := hAbove
+		if y+i == len(source) {
+			row = hBelow
+		} else if y+i >= 0 {
+			row = source[y+i]
+		}
+		for j := -1; j < 2; j++ {
+			nx := x + j
+			if nx < 0 || nx >= width {
+				if !wrapsX {
+					continue
+				}
+				nx = (nx + width) % width
+			}
+			if (i != 0 || j != 0) && row[nx] == 0xFF {
+				n++
+			}
+		}
+	}
+	if source[y][x] == 0xFF && !r.survives(n) || source[y][x] == 0 && r.born(n) {
+		marked = append(marked, cell{x, y})
+	}
+	return marked
+}
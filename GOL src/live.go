@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// liveTurn is the cells that flipped in one turn, sent by the distributor to the live view.
+// Turn 0 flips every alive cell of the initial world.
+type liveTurn struct {
+	turn  int
+	flips []cell
+}
+
+// liveBuffer is the number of messages queued for a browser before it is sent a whole frame instead.
+const liveBuffer = 64
+
+// Binary messages sent to browsers start with one of these, followed by the turn as a little endian uint32.
+const (
+	liveFlips = 1 // Then the number of flips as a uint32 and the x and y of each flip as uint16s
+	liveFrame = 2 // Then the width and height as uint16s and the world as bits, in rows, least significant first
+)
+
+// liveClient is a browser watching the live view.
+type liveClient struct {
+	ws   *wsConn
+	send chan []byte
+
+	// resync is set when the client missed some flips and must be sent a whole frame
+	resync bool
+}
+
+// liveView keeps a copy of the world from the flips sent by the distributor
+// and forwards the flips of each turn to every browser watching.
+type liveView struct {
+	p     golParams
+	world [][]byte
+	turn  int
+
+	turns   chan liveTurn
+	join    chan *liveClient
+	leave   chan *liveClient
+	clients map[*liveClient]bool
+}
+
+func newLiveView(p golParams) *liveView {
+	return &liveView{
+		p:       p,
+		world:   makeWorld(p.imageWidth, p.imageHeight),
+		turns:   make(chan liveTurn, liveBuffer),
+		join:    make(chan *liveClient),
+		leave:   make(chan *liveClient),
+		clients: make(map[*liveClient]bool),
+	}
+}
+
+// run applies the flips of every turn and forwards them until the distributor stops sending turns.
+func (lv *liveView) run() {
+	for {
+		select {
+		case t := <-lv.turns:
+			for _, c := range t.flips {
+				lv.world[c.y][c.x] ^= 0xFF
+			}
+			lv.turn = t.turn
+
+			msg := lv.encodeFlips(t)
+			for c := range lv.clients {
+				lv.forward(c, msg)
+			}
+
+		case c := <-lv.join:
+			lv.clients[c] = true
+			c.resync = true
+			lv.forward(c, nil)
+
+		case c := <-lv.leave:
+			if lv.clients[c] {
+				delete(lv.clients, c)
+				close(c.send)
+			}
+		}
+	}
+}
+
+// forward queues msg for c without waiting. Clients that fall behind are sent a whole frame once they catch up.
+func (lv *liveView) forward(c *liveClient, msg []byte) {
+	if c.resync {
+		msg = lv.encodeFrame()
+	}
+	select {
+	case c.send <- msg:
+		c.resync = false
+	default:
+		c.resync = true
+	}
+}
+
+func (lv *liveView) encodeFlips(t liveTurn) []byte {
+	msg := make([]byte, 9, 9+4*len(t.flips))
+	msg[0] = liveFlips
+	binary.LittleEndian.PutUint32(msg[1:], uint32(t.turn))
+	binary.LittleEndian.PutUint32(msg[5:], uint32(len(t.flips)))
+	for _, c := range t.flips {
+		msg = append(msg, byte(c.x), byte(c.x>>8), byte(c.y), byte(c.y>>8))
+	}
+	return msg
+}
+
+func (lv *liveView) encodeFrame() []byte {
+	w, h := lv.p.imageWidth, lv.p.imageHeight
+	msg := make([]byte, 9+(w*h+7)/8)
+	msg[0] = liveFrame
+	binary.LittleEndian.PutUint32(msg[1:], uint32(lv.turn))
+	binary.LittleEndian.PutUint16(msg[5:], uint16(w))
+	binary.LittleEndian.PutUint16(msg[7:], uint16(h))
+	for y := range lv.world {
+		for x, v := range lv.world[y] {
+			if v != 0 {
+				i := y*w + x
+				msg[9+i/8] |= 1 << uint(i%8)
+			}
+		}
+	}
+	return msg
+}
+
+// liveCommand is a button pressed on the page, sent as JSON text.
+type liveCommand struct {
+	Command string `json:"command"`
+}
+
+var liveCommands = map[string]controlCommand{
+	"status": controlStatus,
+	"pause":  controlPause,
+	"play":   controlResume,
+	"step":   controlStep,
+	"save":   controlSave,
+	"quit":   controlQuit,
+}
+
+// serveLive streams the world to a browser and carries out the commands it sends.
+// Replies to commands are sent back as JSON text.
+func (api controlAPI) serveLive(w http.ResponseWriter, r *http.Request) {
+	ws, err := upgradeWebSocket(w, r)
+	if err != nil {
+		return
+	}
+	defer ws.Close()
+
+	c := &liveClient{ws: ws, send: make(chan []byte, liveBuffer)}
+	api.live.join <- c
+	go func() {
+		for msg := range c.send {
+			if ws.writeMessage(wsBinary, msg) != nil {
+				break
+			}
+		}
+		ws.Close()
+		// Let the live view close send, then drain anything still queued
+		for range c.send {
+		}
+	}()
+	defer func() {
+		api.live.leave <- c
+	}()
+
+	for {
+		opcode, payload, err := ws.readMessage()
+		if err != nil {
+			return
+		}
+		var cmd liveCommand
+		if opcode != wsText || json.Unmarshal(payload, &cmd) != nil {
+			continue
+		}
+		command, ok := liveCommands[strings.ToLower(cmd.Command)]
+		if !ok {
+			continue
+		}
+
+		reply, err := api.send(controlRequest{command: command})
+		var text []byte
+		if err != nil {
+			text, _ = json.Marshal(map[string]string{"error": err.Error()})
+		} else {
+			text, _ = json.Marshal(reply)
+		}
+		if ws.writeMessage(wsText, text) != nil {
+			return
+		}
+	}
+}
+
+// servePage serves the live view page. It has no external assets, so it works offline.
+func (api controlAPI) servePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(livePage))
+}
+
+const livePage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Game of Life</title>
+<style>
+	body { background: #202020; color: #e0e0e0; font-family: sans-serif; margin: 1em; }
+	canvas { background: #000; image-rendering: pixelated; display: block; margin-top: 0.5em; }
+	button { font-size: 1em; margin-right: 0.3em; }
+	#status { margin-left: 1em; }
+</style>
+</head>
+<body>
+<div>
+	<button id="play">Play</button>
+	<button id="pause">Pause</button>
+	<button id="step">Step</button>
+	<button id="save">Save</button>
+	<button id="quit">Quit</button>
+	<span id="status">Connecting...</span>
+</div>
+<canvas id="world"></canvas>
+<script>
+"use strict";
+const canvas = document.getElementById("world");
+const ctx = canvas.getContext("2d");
+const status = document.getElementById("status");
+let width = 0, height = 0, scale = 1, cells = null;
+let turn = 0, paused = false, dirty = [], redraw = false;
+
+const ws = new WebSocket((location.protocol === "https:" ? "wss://" : "ws://") + location.host + "/live");
+ws.binaryType = "arraybuffer";
+
+function send(command) {
+	ws.send(JSON.stringify({command: command}));
+}
+for (const command of ["play", "pause", "step", "save", "quit"]) {
+	document.getElementById(command).onclick = () => send(command);
+}
+
+ws.onopen = () => send("status");
+ws.onclose = () => { status.textContent = "Disconnected at turn " + turn; };
+
+ws.onmessage = (event) => {
+	if (typeof event.data === "string") {
+		const reply = JSON.parse(event.data);
+		if (reply.error) {
+			status.textContent = reply.error;
+			return;
+		}
+		paused = reply.paused;
+		turn = Math.max(turn, reply.turn);
+		return;
+	}
+
+	const data = new DataView(event.data);
+	const kind = data.getUint8(0);
+	turn = data.getUint32(1, true);
+	if (kind === 2) {
+		// A whole frame
+		width = data.getUint16(5, true);
+		height = data.getUint16(7, true);
+		cells = new Uint8Array(width * height);
+		for (let i = 0; i < width * height; i++) {
+			cells[i] = (data.getUint8(9 + (i >> 3)) >> (i & 7)) & 1;
+		}
+		scale = Math.max(1, Math.floor(Math.min(800 / width, 800 / height)));
+		canvas.width = width * scale;
+		canvas.height = height * scale;
+		redraw = true;
+	} else if (kind === 1 && cells) {
+		// The cells that flipped this turn
+		const n = data.getUint32(5, true);
+		for (let i = 0; i < n; i++) {
+			const x = data.getUint16(9 + 4 * i, true), y = data.getUint16(11 + 4 * i, true);
+			cells[y * width + x] ^= 1;
+			dirty.push(y * width + x);
+		}
+	}
+};
+
+// Draw at most once a frame, however many turns arrived
+function draw() {
+	if (cells) {
+		if (redraw) {
+			ctx.fillStyle = "#000";
+			ctx.fillRect(0, 0, canvas.width, canvas.height);
+			dirty = [];
+			for (let i = 0; i < cells.length; i++) {
+				if (cells[i]) dirty.push(i);
+			}
+			redraw = false;
+		}
+		for (const i of dirty) {
+			ctx.fillStyle = cells[i] ? "#fff" : "#000";
+			ctx.fillRect((i % width) * scale, Math.floor(i / width) * scale, scale, scale);
+		}
+		dirty = [];
+		if (ws.readyState === WebSocket.OPEN) {
+			status.textContent = "Turn " + turn + (paused ? " (paused)" : "");
+		}
+	}
+	requestAnimationFrame(draw);
+}
+requestAnimationFrame(draw);
+</script>
+</body>
+</html>
+`
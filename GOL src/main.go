@@ -1,7 +1,13 @@
 package main
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // golParams provides the details of how to run the Game of Life and which image to load.
@@ -10,6 +16,139 @@ type golParams struct {
 	threads     int
 	imageWidth  int
 	imageHeight int
+
+	// The rule defaults to Conway's B3/S23 and the topology to a torus.
+	// Workers apply the rule with kernel, which defaults to counting the neighbours of each cell, see stepRows.
+	rule     rule
+	topology topology
+	kernel   kernel
+
+	// Workers exchange haloDepth rows at a time and run that many turns between exchanges, recomputing
+	// the rows they share with their neighbours. Defaults to 1, and is limited to the rows of the smallest strip.
+	// overlap has workers compute the rows that don't need halos while halos of one row are exchanged.
+	// freeRun has workers run without waiting for the distributor every turn, only for their neighbours' halos.
+	// When the distributor needs the world it stops them at the furthest turn any of them has started.
+	// Free running workers exchange a row of halo every turn, whatever haloDepth is.
+	overlap   bool
+	haloDepth int
+	freeRun   bool
+
+	// Path of the PGM image to load. Defaults to images/<width>x<height>.pgm.
+	// If soup is enabled a random soup is generated instead, and if emptyWorld is set the world starts empty.
+	// Any patterns are then stamped onto the world.
+	inputFile  string
+	soup       soupParams
+	emptyWorld bool
+	patterns   []placement
+
+	// Alive cells are reported every reportTurns turns, or every reportInterval if reportTurns is 0.
+	// Reports go to the sinks selected by reportSinks; none are selected by default.
+	// The events sink sends them on events, dropping those the receiver isn't ready for.
+	reportInterval time.Duration
+	reportTurns    int
+	reportSinks    reportSink
+	reportFile     string
+	events         chan<- report
+
+	// Population statistics for every turn are sent on stats and written to statsFile when either is set.
+	// Every turn is sent, so the game waits for the receiver: stats must be drained every turn or buffered
+	// for every turn of the game.
+	stats     chan<- turnStats
+	statsFile string
+
+	// With detectCycles the world is hashed every turn to find still lifes and oscillators with a period
+	// of at most maxPeriod. Cycles found are sent on cycles, and stop the game if stopOnCycle is set.
+	// A game finds at most one cycle, which is dropped if the receiver isn't ready, so cycles should hold one.
+	// A game that stops on a cycle runs a turn at a time, whatever haloDepth is, so that it stops at the turn
+	// the cycle is found.
+	detectCycles bool
+	stopOnCycle  bool
+	maxPeriod    int
+	cycles       chan<- cycle
+
+	// With track the world is gathered from the workers every turn to look for spaceships and guns.
+	track bool
+
+	// An animated GIF of the run is recorded if record is enabled.
+	record recordParams
+
+	// Images are written as PGM unless image selects PNG, to outputDir or out if it is empty.
+	image     imageParams
+	outputDir string
+
+	// Snapshots of the world are written every so often if snapshots is enabled.
+	snapshots snapshotParams
+
+	// Requests from the HTTP API are carried out by the distributor between turns, alongside keys.
+	control <-chan controlRequest
+
+	// The flips of every turn are sent to the live view of the HTTP API if live is set.
+	live chan<- liveTurn
+
+	// Workers, the distributor and the io goroutine are instrumented for the HTTP API if metrics is set.
+	// workerStats prints how long each worker spent computing, exchanging halos and idle at the end.
+	metrics     *metrics
+	workerStats bool
+
+	// quiet suppresses progress messages and noFinalImage skips writing the image at the end,
+	// for running many games at once in a search.
+	quiet        bool
+	noFinalImage bool
+}
+
+// println prints a progress message unless p is quiet.
+func (p golParams) println(a ...interface{}) {
+	if !p.quiet {
+		fmt.Println(a...)
+	}
+}
+
+// input returns the path of the PGM image to load.
+func (p golParams) input() string {
+	if p.inputFile != "" {
+		return p.inputFile
+	}
+	return "images/" + strconv.Itoa(p.imageWidth) + "x" + strconv.Itoa(p.imageHeight) + ".pgm"
+}
+
+// generated reports whether the io goroutine should generate the world rather than load inputFile.
+func (p golParams) generated() bool {
+	return p.soup.enabled() || p.emptyWorld
+}
+
+// outputName returns the name of the PGM image written after the given turn.
+// Soups include their seed so that the run can be reproduced.
+func (p golParams) outputName(turns int) string {
+	name := strings.Join([]string{strconv.Itoa(p.imageWidth), strconv.Itoa(p.imageHeight), strconv.Itoa(turns)}, "x")
+	if p.soup.enabled() {
+		name += "-seed" + strconv.FormatInt(p.soup.seed, 10)
+	}
+	return name
+}
+
+// outDir returns the directory images are written to.
+func (p golParams) outDir() string {
+	if p.outputDir != "" {
+		return p.outputDir
+	}
+	return "out"
+}
+
+// workers returns the number of workers to split the world between: one per thread,
+// but at least one and no more than one per row.
+func (p golParams) workers() int {
+	if p.threads > p.imageHeight {
+		return p.imageHeight
+	}
+	if p.threads < 1 {
+		return 1
+	}
+	return p.threads
+}
+
+// wantsStats reports whether workers should measure bounding boxes and centroids each turn.
+func (p golParams) wantsStats() bool {
+	return p.stats != nil || p.statsFile != ""
 }
 
 // ioCommand allows requesting behaviour from the io (pgm) goroutine.
@@ -17,13 +156,16 @@ type ioCommand uint8
 
 // This is a way of creating enums in Go.
 // It will evaluate to:
-//		ioOutput 	= 0
-//		ioInput 	= 1
-//		ioCheckIdle = 2
+//		ioInput 	= 0
+//		ioCheckIdle = 1
+//		ioGenerate	= 2
+//		ioQuit		= 3
+// Images are written by handing an ioImage to the io goroutine on its own channel.
 const (
-	ioOutput ioCommand = iota
-	ioInput
+	ioInput ioCommand = iota
 	ioCheckIdle
+	ioGenerate
+	ioQuit
 )
 
 // cell is used as the return type for the testing framework.
@@ -40,7 +182,8 @@ type distributorToIo struct {
 	filename  chan<- string
 	inputVal  <-chan uint8
 
-	worldState chan<- byte
+	images  chan<- ioImage
+	buffers <-chan [][]byte
 }
 
 // ioToDistributor defines all chans that the io goroutine will have to communicate with the distributor goroutine.
@@ -52,7 +195,8 @@ type ioToDistributor struct {
 	filename  <-chan string
 	inputVal  chan<- uint8
 
-	worldState <-chan byte
+	images  <-chan ioImage
+	buffers chan<- [][]byte
 }
 
 // distributorChans stores all the chans that the distributor goroutine will use.
@@ -70,6 +214,11 @@ type ioChans struct {
 // It places the created channels in the relevant structs.
 // It returns an array of alive cells returned by the distributor.
 func gameOfLife(p golParams, keyChan <-chan rune) []cell {
+	p.threads = p.workers()
+	if p.workerStats && p.metrics == nil {
+		p.metrics = newMetrics(p.threads)
+	}
+
 	// Default channels from structs
 	var dChans distributorChans
 	var ioChans ioChans
@@ -90,15 +239,37 @@ func gameOfLife(p golParams, keyChan <-chan rune) []cell {
 	dChans.io.inputVal = inputVal
 	ioChans.distributor.inputVal = inputVal
 
-	worldState := make(chan byte)
-	dChans.io.worldState = worldState
-	ioChans.distributor.worldState = worldState
+	// Images and their buffers are buffered so that the distributor doesn't wait for images to be written
+	images := make(chan ioImage, ioBuffers)
+	dChans.io.images = images
+	ioChans.distributor.images = images
+
+	buffers := make(chan [][]byte, ioBuffers)
+	dChans.io.buffers = buffers
+	ioChans.distributor.buffers = buffers
 
 	aliveCells := make(chan []cell)
 
+	if p.rule == (rule{}) {
+		p.rule = conway
+	}
+
+	// Pick a seed now so that it is the same for the io goroutine and the output filenames
+	if p.soup.enabled() && p.soup.seed == 0 {
+		p.soup.seed = time.Now().UnixNano()
+	}
+
 	// Initialize variables for y values
 	yParams := make([]int, p.threads + 1)
 	div := p.imageHeight/p.threads
+
+	// Halos can't be deeper than the smallest strip, which has div rows
+	if p.haloDepth > div {
+		p.haloDepth = div
+	}
+	if p.haloDepth < 1 {
+		p.haloDepth = 1
+	}
 	total := div * p.threads
 	diff := 0
 
@@ -116,34 +287,36 @@ func gameOfLife(p golParams, keyChan <-chan rune) []cell {
 	}
 
 	// Slice of channels for worker and distributor
-	signalWork := make([]chan struct{}, p.threads)
-	signalFinish := make([]chan struct{}, p.threads)
+	signalWork := make([]chan int, p.threads)
+	limit := &freeRunLimit{}
+	signalFinish := make([]chan stripStats, p.threads)
 	signalComplete := make([]chan struct{}, p.threads)
 
 	state := make([]chan struct{}, p.threads)
-	pause := make([]chan struct{}, p.threads)
-
-	tick := make([]chan struct{}, p.threads)
-	aliveNum := make([]chan int, p.threads)
 
-	// Slice of channels of byte for halo implementation
-	aComs := make([]chan byte, p.threads)
-	bComs := make([]chan byte, p.threads)
+	// Slice of channels of rows for halo implementation
+	aComs := make([]chan [][]byte, p.threads)
+	bComs := make([]chan [][]byte, p.threads)
 
 	// Initialise all the channels for communication between workers before calling workers
 	for t := 0; t < p.threads; t++ {
-		signalWork[t] = make(chan struct{})
-		signalFinish[t] = make(chan struct{})
+		signalWork[t] = make(chan int)
+		if p.freeRun {
+			signalFinish[t] = make(chan stripStats, freeRunLead)
+		} else {
+			signalFinish[t] = make(chan stripStats, p.haloDepth)
+		}
 		signalComplete[t] = make(chan struct{})
 
 		state[t] = make(chan struct{})
-		pause[t] = make(chan struct{})
 
-		tick[t] = make(chan struct{})
-		aliveNum[t] = make(chan int)
+		aComs[t] = make(chan [][]byte, 1)
+		bComs[t] = make(chan [][]byte, 1)
+	}
 
-		aComs[t] = make(chan byte)
-		bComs[t] = make(chan byte)
+	// Calculate y parameters
+	for i := 1; i < p.threads + 1; i++  {
+		yParams[i] += yParams[i - 1]
 	}
 
 	// -- GOL --
@@ -151,23 +324,18 @@ func gameOfLife(p golParams, keyChan <-chan rune) []cell {
 	// Instantiate workers
 	c := make([]chan byte, p.threads)
 	for t := 0; t < p.threads; t++ {
-		// If worker is even, send halos first
 		c[t] = make(chan byte)
-		go worker(p, c[t], yParams[t + 1], (t % 2) == 0,
-			signalWork[t], signalFinish[t], signalComplete[t], state[t], pause[t], tick[t], aliveNum[t],
-			aComs[((t - 1) + p.threads) % p.threads], bComs[(t + 1) % p.threads], aComs[t], bComs[t])
-	}
-
-	// Calculate y parameters
-	for i := 1; i < p.threads + 1; i++  {
-		yParams[i] += yParams[i - 1]
+		go worker(p, c[t], yParams[t], yParams[t + 1] - yParams[t],
+			signalWork[t], limit, signalComplete[t], state[t], signalFinish[t],
+			aComs[((t - 1) + p.threads) % p.threads], bComs[(t + 1) % p.threads], aComs[t], bComs[t],
+			p.metrics.worker(t))
 	}
 
 	// Channel to send parameters to distributor
 	yChan := make(chan int)
 
 	go distributor(p, dChans, aliveCells, c, yChan,
-		keyChan, signalWork, signalFinish, signalComplete, state, pause, tick, aliveNum)
+		keyChan, signalWork, limit, signalComplete, state, signalFinish)
 	go pgmIo(p, ioChans)
 
 	// Send parameters to distributor
@@ -181,7 +349,13 @@ func gameOfLife(p golParams, keyChan <-chan rune) []cell {
 
 // main is the function called when starting Game of Life with 'make gol'
 // Do not edit until Stage 2.
+// 'gameoflife search' runs a batch soup search instead, see runSearch.
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "search" {
+		runSearch(os.Args[2:])
+		return
+	}
+
 	var params golParams
 	key := make(chan rune)
 
@@ -203,12 +377,382 @@ func main() {
 		512,
 		"Specify the height of the image. Defaults to 512.")
 
+	configFile := flag.String(
+		"config",
+		"",
+		"Load flags from a JSON file mapping flag names to values. Flags on the command line override the file.")
+
+	headless := flag.Bool(
+		"headless",
+		false,
+		"Run without a terminal. Keys are ignored, and an interrupt writes the final image and quits.")
+
+	flag.IntVar(
+		&params.turns,
+		"turns",
+		0,
+		"Specify the number of turns to run. Defaults to 0 (run until quit).")
+
+	flag.StringVar(
+		&params.inputFile,
+		"input",
+		"",
+		"Specify the PGM image to load. Defaults to images/<width>x<height>.pgm.")
+
+	flag.StringVar(
+		&params.outputDir,
+		"out",
+		"out",
+		"Specify the directory images are written to. Defaults to out.")
+
+	ruleName := flag.String(
+		"rule",
+		"B3/S23",
+		"Specify the rule in B/S notation. Defaults to B3/S23.")
+
+	topologyName := flag.String(
+		"topology",
+		"torus",
+		"Specify the topology as one of torus, cylinder or plane. Defaults to torus.")
+
+	kernelName := flag.String(
+		"kernel",
+		"cells",
+		"Specify the kernel workers apply the rule with, as one of cells, lut or rowsum. Defaults to cells.")
+
+	flag.DurationVar(
+		&params.reportInterval,
+		"report",
+		2*time.Second,
+		"Specify how often to report the number of alive cells. Defaults to 2s.")
+
+	flag.IntVar(
+		&params.reportTurns,
+		"report-turns",
+		0,
+		"Report the number of alive cells every N turns instead of on a timer. Defaults to 0 (use -report).")
+
+	sinks := flag.String(
+		"report-sinks",
+		"stdout",
+		"Specify where reports go as a comma separated list of stdout, csv or none. Defaults to stdout.")
+
+	flag.StringVar(
+		&params.reportFile,
+		"report-file",
+		"alive.csv",
+		"Specify the CSV file used by the csv report sink. Defaults to alive.csv.")
+
+	flag.StringVar(
+		&params.statsFile,
+		"population-csv",
+		"",
+		"Write births, deaths, bounding box, centroid and density for every turn to this CSV file.")
+
+	flag.BoolVar(
+		&params.detectCycles,
+		"detect-cycles",
+		false,
+		"Report when the world becomes still or starts oscillating.")
+
+	flag.BoolVar(
+		&params.stopOnCycle,
+		"stop-on-cycle",
+		false,
+		"Stop and write the final image when the world becomes still or starts oscillating. Implies -detect-cycles.")
+
+	flag.IntVar(
+		&params.maxPeriod,
+		"max-period",
+		defaultMaxPeriod,
+		"Specify the longest oscillator period looked for by -detect-cycles.")
+
+	flag.BoolVar(
+		&params.track,
+		"track",
+		false,
+		"Report spaceships and guns found while running. Gathers the world every turn, which is slow.")
+
+	soup := flag.Bool(
+		"soup",
+		false,
+		"Start from a random soup instead of loading an image.")
+
+	soupSize := flag.String(
+		"soup-size",
+		"16x16",
+		"Specify the size of the soup, placed in the middle of the world. Defaults to 16x16.")
+
+	soupSymmetry := flag.String(
+		"soup-symmetry",
+		"C1",
+		"Specify the symmetry of the soup as one of C1, C2, C4 or D8. Defaults to C1.")
+
+	soupParams := soupParams{}
+
+	flag.Float64Var(
+		&soupParams.density,
+		"soup-density",
+		0.5,
+		"Specify the fraction of cells in the soup that start alive. Defaults to 0.5.")
+
+	flag.Int64Var(
+		&soupParams.seed,
+		"soup-seed",
+		0,
+		"Specify the seed of the soup. Defaults to a random seed, which is printed.")
+
+	pattern := flag.String(
+		"pattern",
+		"",
+		"Place a pattern from the library, e.g. glider, gosper or acorn, in an empty world.")
+
+	at := flag.String(
+		"at",
+		"0,0",
+		"Specify the position of the top left corner of -pattern as x,y. Defaults to 0,0.")
+
+	stamp := flag.Bool(
+		"stamp",
+		false,
+		"Stamp -pattern onto the loaded image or soup instead of an empty world.")
+
+	sceneFile := flag.String(
+		"scene",
+		"",
+		"Build the initial world from a JSON scene file. Overrides -w and -h.")
+
+	flag.StringVar(
+		&params.record.file,
+		"record",
+		"",
+		"Record the run as an animated GIF with this filename.")
+
+	flag.IntVar(
+		&params.record.every,
+		"record-every",
+		1,
+		"Record a frame every N turns. Defaults to 1.")
+
+	flag.IntVar(
+		&params.record.scale,
+		"record-scale",
+		1,
+		"Specify the size in pixels of each cell in the recording. Defaults to 1.")
+
+	flag.IntVar(
+		&params.record.delay,
+		"record-delay",
+		5,
+		"Specify the delay between frames of the recording in 100ths of a second. Defaults to 5.")
+
+	recordAlive := flag.String(
+		"record-alive",
+		"#ffffff",
+		"Specify the colour of alive cells in the recording. Defaults to #ffffff.")
+
+	recordDead := flag.String(
+		"record-dead",
+		"#000000",
+		"Specify the colour of dead cells in the recording. Defaults to #000000.")
+
+	recordCrop := flag.String(
+		"record-crop",
+		"",
+		"Only record a region of the world, given as x,y,width,height.")
+
+	imageFormat := flag.String(
+		"image-format",
+		"pgm",
+		"Specify the format of images written, pgm or png. Defaults to pgm.")
+
+	imageScheme := flag.String(
+		"image-scheme",
+		"classic",
+		"Specify the colour scheme of PNG images as one of classic, paper, heat or phosphor. Defaults to classic.")
+
+	flag.IntVar(
+		&params.image.scale,
+		"image-scale",
+		1,
+		"Specify the size in pixels of each cell in PNG images. Gridlines are drawn from 6. Defaults to 1.")
+
+	flag.BoolVar(
+		&params.image.age,
+		"image-age",
+		false,
+		"Colour alive cells in PNG images by how many turns they have been alive.")
+
+	snapshotEvery := flag.String(
+		"snapshot-every",
+		"0",
+		"Write a snapshot every N turns, or every duration such as 30s. Defaults to 0 (no snapshots).")
+
+	snapshotKeep := flag.Int(
+		"snapshot-keep",
+		0,
+		"Only keep the last K snapshots. Defaults to 0 (keep every snapshot).")
+
+	httpAddr := flag.String(
+		"http",
+		"",
+		"Serve an HTTP API to control the game, and a live view of it, on this address, e.g. :8080.")
+
+	flag.BoolVar(
+		&params.overlap,
+		"overlap",
+		false,
+		"Compute the rows that don't need halos while the halos are exchanged.")
+
+	flag.IntVar(
+		&params.haloDepth,
+		"halo-depth",
+		1,
+		"Specify how many rows of halo workers exchange, so that they only synchronise every N turns. Defaults to 1.")
+
+	flag.BoolVar(
+		&params.freeRun,
+		"free-run",
+		false,
+		"Let workers run ahead of the distributor, only stopping them at a common turn when the world is needed.")
+
+	flag.BoolVar(
+		&params.workerStats,
+		"stats",
+		false,
+		"Print the time each worker spent computing, exchanging halos and idle per turn at the end.")
+
+	census := flag.Bool(
+		"census",
+		false,
+		"Print a census of the objects in the final world.")
+
 	flag.Parse()
 
-	params.turns = 9999999999999
+	var err error
+	if *configFile != "" {
+		err = loadConfig(flag.CommandLine, *configFile)
+	}
+	if err == nil {
+		params.rule, err = parseRule(*ruleName)
+	}
+	if err == nil {
+		params.topology, err = parseTopology(*topologyName)
+	}
+	if err == nil {
+		params.kernel, err = parseKernel(*kernelName)
+	}
+	if err == nil {
+		params.reportSinks, err = parseReportSinks(*sinks)
+	}
+	if err == nil && params.reportSinks&reportEvents != 0 {
+		err = errors.New("the events report sink is only available to programs that call gameOfLife")
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	startControlServer(params)
-	go getKeyboardCommand(key)
-	gameOfLife(params, key)
-	StopControlServer()
+	if *soup {
+		soupParams.generate = true
+		soupParams.width, soupParams.height, err = parseSoupSize(*soupSize)
+		if err == nil {
+			soupParams.symmetry, err = parseSymmetry(*soupSymmetry)
+		}
+		if err == nil {
+			err = soupParams.checkDensity()
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		params.soup = soupParams
+	}
+
+	if *pattern != "" {
+		pl := placement{name: *pattern}
+		pl.x, pl.y, err = parsePosition(*at)
+		if err == nil {
+			_, err = lookupPattern(pl.name)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		params.patterns = append(params.patterns, pl)
+		params.emptyWorld = !*stamp
+	}
+
+	params.record.alive, err = parseColour(*recordAlive)
+	if err == nil {
+		params.record.dead, err = parseColour(*recordDead)
+	}
+	if err == nil && *recordCrop != "" {
+		params.record.crop, err = parseRegion(*recordCrop)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	params.image.format, err = parseImageFormat(*imageFormat)
+	if err == nil {
+		params.image.scheme, err = parseScheme(*imageScheme)
+	}
+	if err == nil {
+		params.snapshots, err = parseSnapshotEvery(*snapshotEvery)
+		params.snapshots.keep = *snapshotKeep
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *sceneFile != "" {
+		var s scene
+		s, err = loadScene(*sceneFile)
+		if err == nil {
+			err = s.apply(&params)
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	// The size of the world is only known once any scene is applied
+	if err = params.record.checkCrop(params.imageWidth, params.imageHeight); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if params.turns <= 0 {
+		params.turns = 9999999999999
+	}
+	params.detectCycles = params.detectCycles || params.stopOnCycle
+
+	if *httpAddr != "" {
+		params.metrics = newMetrics(params.workers())
+		params.control, params.live, err = startControlAPI(params, *httpAddr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+	}
+
+	var alive []cell
+	if *headless {
+		printParams(params)
+		go quitOnInterrupt(key)
+		alive = gameOfLife(params, key)
+	} else {
+		startControlServer(params)
+		go getKeyboardCommand(key)
+		alive = gameOfLife(params, key)
+		StopControlServer()
+	}
+
+	if *census {
+		printCensus(os.Stdout, takeCensus(params, alive))
+	}
 }
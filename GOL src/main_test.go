@@ -1,9 +1,30 @@
 package main
 
 import (
+	"bufio"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"flag"
 	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"math"
+	"math/rand"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test(t *testing.T) {
@@ -324,6 +345,1667 @@ func Test(t *testing.T) {
 	}
 }
 
+// captureStdout returns everything f writes to os.Stdout.
+func captureStdout(t *testing.T, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		out <- string(b)
+	}()
+	f()
+	os.Stdout = stdout
+	_ = w.Close()
+	return <-out
+}
+
+// TestReports checks the lines written by the stdout and csv report sinks against the statistics of every turn,
+// and that nobody reading the events sink doesn't hold up the game.
+func TestReports(t *testing.T) {
+	file, err := ioutil.TempFile("", "alive*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+	defer os.Remove(file.Name())
+
+	stats := make(chan turnStats, 20)
+	p := golParams{
+		turns:        20,
+		threads:      3,
+		imageWidth:   32,
+		imageHeight:  32,
+		soup:         soupParams{generate: true, density: 0.4, seed: 26},
+		reportTurns:  5,
+		reportSinks:  reportStdout | reportCSV | reportEvents,
+		reportFile:   file.Name(),
+		events:       make(chan report),
+		stats:        stats,
+		quiet:        true,
+		noFinalImage: true,
+	}
+	stdout := captureStdout(t, func() {
+		runWithin(t, p, 10*time.Second)
+	})
+	close(stats)
+
+	// Births and deaths are counted since the previous report
+	var lines []string
+	var rows [][]string
+	births, deaths := 0, 0
+	for ts := range stats {
+		births += ts.births
+		deaths += ts.deaths
+		if ts.turn%p.reportTurns == 0 {
+			lines = append(lines, fmt.Sprintln("No. of alive cells: ", ts.alive))
+			rows = append(rows, []string{
+				strconv.Itoa(ts.turn), strconv.Itoa(ts.alive), strconv.Itoa(births), strconv.Itoa(deaths),
+			})
+			births, deaths = 0, 0
+		}
+	}
+	if expected := strings.Join(lines, ""); stdout != expected {
+		t.Errorf("Expected stdout\n%s\ngot\n%s", expected, stdout)
+	}
+
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(strings.NewReader(string(data))).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != len(rows)+1 {
+		t.Fatalf("Expected a header and %d rows, got\n%s", len(rows), data)
+	}
+	if header := strings.Join(records[0], ","); header != "turn,wallclock,alive,births,deaths" {
+		t.Errorf("Unexpected header %s", header)
+	}
+	for i, record := range records[1:] {
+		if _, err := time.Parse(time.RFC3339Nano, record[1]); err != nil {
+			t.Errorf("Row %d: %v", i+1, err)
+		}
+		got := strings.Join(append([]string{record[0]}, record[2:]...), ",")
+		if expected := strings.Join(rows[i], ","); got != expected {
+			t.Errorf("Row %d: expected %s, got %s", i+1, expected, got)
+		}
+	}
+}
+
+// TestStats checks the statistics of a blinker that crosses the boundary between strips, and that the statistics
+// combined from several workers are the same as from one.
+func TestStats(t *testing.T) {
+	// collect runs p and returns the statistics of every turn, without the densities of the strips
+	collect := func(p golParams) []turnStats {
+		stats := make(chan turnStats, p.turns)
+		p.stats = stats
+		runWithin(t, p, 10*time.Second)
+		close(stats)
+		var turns []turnStats
+		for ts := range stats {
+			ts.stripDensity = nil
+			turns = append(turns, ts)
+		}
+		return turns
+	}
+
+	file, err := ioutil.TempFile("", "population*.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+	defer os.Remove(file.Name())
+
+	blinker := golParams{
+		turns:        2,
+		imageWidth:   16,
+		imageHeight:  16,
+		emptyWorld:   true,
+		patterns:     []placement{{name: "blinker", x: 5, y: 4}},
+		statsFile:    file.Name(),
+		quiet:        true,
+		noFinalImage: true,
+	}
+	expected := fmt.Sprint([]turnStats{
+		{turn: 1, alive: 3, births: 2, deaths: 2, minX: 6, minY: 3, maxX: 6, maxY: 5, centroidX: 6, centroidY: 4},
+		{turn: 2, alive: 3, births: 2, deaths: 2, minX: 5, minY: 4, maxX: 7, maxY: 4, centroidX: 6, centroidY: 4},
+	})
+	for _, blinker.threads = range []int{1, 4} {
+		if got := fmt.Sprint(collect(blinker)); got != expected {
+			t.Errorf("Blinker with %d workers: expected\n%s\ngot\n%s", blinker.threads, expected, got)
+		}
+	}
+
+	// The file is from the last run, with 4 strips of 4 rows
+	data, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedCSV := "turn,alive,births,deaths,min_x,min_y,max_x,max_y,centroid_x,centroid_y,density," +
+		"density_0,density_1,density_2,density_3\n" +
+		"1,3,2,2,6,3,6,5,6.000,4.000,0.011719,0.015625,0.031250,0.000000,0.000000\n" +
+		"2,3,2,2,5,4,7,4,6.000,4.000,0.011719,0.000000,0.046875,0.000000,0.000000\n"
+	if string(data) != expectedCSV {
+		t.Errorf("Expected the population CSV\n%s\ngot\n%s", expectedCSV, data)
+	}
+
+	soup := golParams{
+		turns:        30,
+		threads:      1,
+		imageWidth:   40,
+		imageHeight:  40,
+		soup:         soupParams{generate: true, density: 0.4, seed: 27},
+		quiet:        true,
+		noFinalImage: true,
+	}
+	expected = fmt.Sprint(collect(soup))
+	for _, soup.threads = range []int{2, 3, 5, 8} {
+		if got := fmt.Sprint(collect(soup)); got != expected {
+			t.Errorf("Soup with %d workers: expected\n%s\ngot\n%s", soup.threads, expected, got)
+		}
+	}
+}
+
+func TestCycles(t *testing.T) {
+	tests := []struct {
+		name          string
+		p             golParams
+		expectedCycle cycle
+		expectedAlive int
+	}{
+		{"block", golParams{
+			turns:       100,
+			threads:     2,
+			imageWidth:  17,
+			imageHeight: 17,
+			inputFile:   "images/block.pgm",
+		}, cycle{turn: 0, period: 1}, 4},
+
+		{"blinker", golParams{
+			turns:       100,
+			threads:     2,
+			imageWidth:  17,
+			imageHeight: 17,
+			inputFile:   "images/blinker.pgm",
+		}, cycle{turn: 0, period: 2}, 3},
+
+		{"pulsar", golParams{
+			turns:       100,
+			threads:     4,
+			imageWidth:  17,
+			imageHeight: 17,
+			inputFile:   "images/pulsar.pgm",
+		}, cycle{turn: 0, period: 3}, 48},
+
+		{"pulsar-depth", golParams{
+			turns:       100,
+			threads:     4,
+			haloDepth:   4,
+			imageWidth:  17,
+			imageHeight: 17,
+			inputFile:   "images/pulsar.pgm",
+		}, cycle{turn: 0, period: 3}, 48},
+
+		{"pulsar-free-run", golParams{
+			turns:       100,
+			threads:     4,
+			freeRun:     true,
+			imageWidth:  17,
+			imageHeight: 17,
+			inputFile:   "images/pulsar.pgm",
+		}, cycle{turn: 0, period: 3}, 48},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cycles := make(chan cycle, 1)
+			test.p.detectCycles = true
+			test.p.stopOnCycle = true
+			test.p.cycles = cycles
+
+			alive := gameOfLife(test.p, nil)
+			select {
+			case c := <-cycles:
+				if c != test.expectedCycle {
+					t.Errorf("Expected %+v, got %+v", test.expectedCycle, c)
+				}
+				// The game stops at the turn the cycle is found, when the world repeats the turn it started at
+				world := readReferenceImage(t, test.p.inputFile)
+				for turn := 1; turn <= c.turn+c.period; turn++ {
+					world = referenceStep(world, conway, torus)
+				}
+				if expected := aliveCells(world); fmt.Sprint(alive) != fmt.Sprint(expected) {
+					t.Errorf("Expected the world at turn %d after stopping, got %d alive cells instead of %d",
+						c.turn+c.period, len(alive), len(expected))
+				}
+			default:
+				t.Errorf("No cycle detected in %d turns", test.p.turns)
+			}
+			if len(alive) != test.expectedAlive {
+				t.Errorf("Expected %d alive cells after stopping, got %d", test.expectedAlive, len(alive))
+			}
+		})
+	}
+
+	// Nobody reading cycles doesn't hold up the game
+	p := tests[0].p
+	p.detectCycles, p.stopOnCycle, p.cycles = true, true, make(chan cycle)
+	p.quiet, p.noFinalImage = true, true
+	runWithin(t, p, 10*time.Second)
+}
+
+// TestCensus checks the census of known objects placed apart, including two blocks a cell apart,
+// which are close enough to be found as one cluster but don't interact, and oscillators made of several pieces.
+func TestCensus(t *testing.T) {
+	p := golParams{imageWidth: 64, imageHeight: 64, rule: conway}
+	alive := placed(t, p,
+		placement{name: "block", x: 2, y: 2},
+		placement{name: "beehive", x: 10, y: 2},
+		placement{name: "blinker", x: 20, y: 2},
+		placement{name: "glider", x: 30, y: 10},
+		placement{name: "toad", x: 40, y: 2},
+		placement{name: "pulsar", x: 40, y: 40},
+		placement{name: "block", x: 2, y: 20},
+		placement{name: "block", x: 5, y: 20},
+	)
+	// The toad is placed across the edge of the world
+	alive = append(alive, placed(t, p, placement{name: "toad", x: 62, y: 30})...)
+
+	got := make(map[string]string)
+	for _, e := range takeCensus(p, alive) {
+		got[e.name] = e.code + " " + strconv.Itoa(e.count)
+	}
+	expected := map[string]string{
+		"block":   "xs4 3",
+		"beehive": "xs6 1",
+		"blinker": "xp2 1",
+		"glider":  "xq4 1",
+		"toad":    "xp2 2",
+		"pulsar":  "xp3 1",
+	}
+	if fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("Expected the census %v, got %v", expected, got)
+	}
+
+	// Blocks on opposite edges of a plane don't touch, so aren't one object as they would be on a torus
+	q := golParams{imageWidth: 32, imageHeight: 32, rule: conway, topology: plane}
+	census := takeCensus(q, placed(t, q,
+		placement{name: "block", x: 0, y: 10},
+		placement{name: "block", x: 30, y: 10},
+		placement{name: "block", x: 10, y: 0},
+		placement{name: "block", x: 10, y: 30},
+	))
+	if len(census) != 1 || census[0].name != "block" || census[0].count != 4 {
+		t.Errorf("Expected 4 blocks on the edges of a plane, got %+v", census)
+	}
+
+	// A block dies without S3, and isn't named under another rule
+	p.rule, _ = parseRule("B3/S2")
+	census = takeCensus(p, placed(t, p, placement{name: "block", x: 2, y: 2}))
+	if len(census) != 1 || census[0].code != "zz" || census[0].name != "" {
+		t.Errorf("Expected an unnamed zz under %v, got %+v", p.rule, census)
+	}
+}
+
+// placed returns the cells of the given patterns from the library, wrapped around the world in p.
+func placed(t *testing.T, p golParams, patterns ...placement) []cell {
+	var alive []cell
+	for _, pl := range patterns {
+		cells, err := pl.cells(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		alive = append(alive, cells...)
+	}
+	return alive
+}
+
+// track runs a tracker over the given turns of a world, stepped by referenceStep, and returns what it reports.
+func track(p golParams, world [][]byte, turns int) ([]spaceship, []emitter) {
+	tk := newTracker(p)
+	var ships []spaceship
+	var emitters []emitter
+	for turn := 0; turn <= turns; turn++ {
+		s, e := tk.add(turn, aliveCells(world))
+		ships = append(ships, s...)
+		emitters = append(emitters, e...)
+		world = referenceStep(world, p.rule, p.topology)
+	}
+	return ships, emitters
+}
+
+func TestTracker(t *testing.T) {
+	p := golParams{imageWidth: 32, imageHeight: 32, rule: conway}
+	world := makeWorld(p.imageWidth, p.imageHeight)
+	for _, c := range placed(t, p, placement{name: "glider", x: 10, y: 10}) {
+		world[c.y][c.x] = 0xFF
+	}
+	ships, emitters := track(p, world, 40)
+	if len(ships) != 1 || ships[0].name != "glider" || ships[0].period != 4 || ships[0].dx != 1 || ships[0].dy != 1 {
+		t.Errorf("Expected a glider with period 4 moving 1 1, got %+v", ships)
+	}
+	if len(emitters) != 0 {
+		t.Errorf("Expected no emitters from a glider, got %+v", emitters)
+	}
+
+	p = golParams{imageWidth: 128, imageHeight: 128, rule: conway}
+	world = makeWorld(p.imageWidth, p.imageHeight)
+	for _, c := range placed(t, p, placement{name: "gosper", x: 2, y: 2}) {
+		world[c.y][c.x] = 0xFF
+	}
+	_, emitters = track(p, world, 240)
+	if len(emitters) != 1 || emitters[0].period != 30 || emitters[0].growth != 5 {
+		t.Errorf("Expected the Gosper gun to grow by 5 every 30 turns, got %+v", emitters)
+	}
+
+	// Some of these soups grow by the same amount for a few turns in a row while they settle
+	for seed := int64(1); seed <= 8; seed++ {
+		p = golParams{imageWidth: 96, imageHeight: 96, rule: conway,
+			soup: soupParams{generate: true, width: 16, height: 16, density: 0.3, seed: seed}}
+		_, emitters = track(p, soupWorld(p), 300)
+		if len(emitters) != 0 {
+			t.Errorf("Expected no emitters from soup %d, got %+v", seed, emitters)
+		}
+	}
+}
+
+func TestSoup(t *testing.T) {
+	p := golParams{imageWidth: 32, imageHeight: 32,
+		soup: soupParams{generate: true, width: 20, height: 12, density: 0.5, seed: 31}}
+	if fmt.Sprint(soupWorld(p)) != fmt.Sprint(soupWorld(p)) {
+		t.Error("Expected the same seed to generate the same soup")
+	}
+	other := p
+	other.soup.seed++
+	if fmt.Sprint(soupWorld(p)) == fmt.Sprint(soupWorld(other)) {
+		t.Error("Expected different seeds to generate different soups")
+	}
+
+	// A density of 0 still generates a soup, which is empty, instead of loading an image
+	for density, expected := range map[float64]int{0: 0, 1: 20 * 12} {
+		q := p
+		q.soup.density = density
+		if !q.soup.enabled() || q.soup.checkDensity() != nil {
+			t.Errorf("Expected a soup with density %v", density)
+		}
+		if alive := len(aliveCells(soupWorld(q))); alive != expected {
+			t.Errorf("Expected %d alive cells with density %v, got %d", expected, density, alive)
+		}
+	}
+	for _, density := range []float64{-0.1, 1.5, math.NaN()} {
+		q := p
+		q.soup.density = density
+		if q.soup.checkDensity() == nil {
+			t.Errorf("Expected density %v to be rejected", density)
+		}
+	}
+
+	// Every symmetry maps the soup onto itself, where a soup is w x h cells with its corner at x0, y0
+	rotate180 := func(x, y, w, h int) (int, int) { return w - 1 - x, h - 1 - y }
+	rotate90 := func(x, y, w, h int) (int, int) { return w - 1 - y, x }
+	reflect := func(x, y, w, h int) (int, int) { return w - 1 - x, y }
+	tests := []struct {
+		symmetry   soupSymmetry
+		w, h       int
+		transforms []func(x, y, w, h int) (int, int)
+	}{
+		{symmetryC1, 20, 12, nil},
+		{symmetryC2, 20, 12, []func(x, y, w, h int) (int, int){rotate180}},
+		{symmetryC4, 12, 12, []func(x, y, w, h int) (int, int){rotate90}},
+		{symmetryD8, 12, 12, []func(x, y, w, h int) (int, int){rotate90, reflect}},
+	}
+	for _, test := range tests {
+		t.Run(test.symmetry.String(), func(t *testing.T) {
+			q := p
+			q.soup.symmetry = test.symmetry
+			world := soupWorld(q)
+			x0, y0 := (q.imageWidth-test.w)/2, (q.imageHeight-test.h)/2
+
+			alive := 0
+			for y := range world {
+				for x := range world[y] {
+					if world[y][x] == 0 {
+						continue
+					}
+					alive++
+					if x < x0 || x >= x0+test.w || y < y0 || y >= y0+test.h {
+						t.Fatalf("Expected a %dx%d soup, found %d, %d alive", test.w, test.h, x, y)
+					}
+				}
+			}
+			if alive == 0 {
+				t.Fatal("Expected some alive cells in the soup")
+			}
+
+			for y := 0; y < test.h; y++ {
+				for x := 0; x < test.w; x++ {
+					for _, transform := range test.transforms {
+						tx, ty := transform(x, y, test.w, test.h)
+						if world[y0+y][x0+x] != world[y0+ty][x0+tx] {
+							t.Fatalf("Expected %d, %d to match %d, %d in the soup", x, y, tx, ty)
+						}
+					}
+				}
+			}
+		})
+	}
+}
+
+// search runs a search and returns the result lines sorted by seed and the totals.
+func search(p golParams, first, count int64, parallel int, stop chan struct{}) ([]string, []censusEntry) {
+	found := make(chan searchResult)
+	go searchSoups(p, first, count, parallel, stop, found)
+	var lines []string
+	totals := make(searchTotals)
+	for r := range found {
+		lines = append(lines, r.line())
+		totals.add(r)
+	}
+	sort.Slice(lines, func(i, j int) bool {
+		a, _ := strconv.ParseInt(strings.Split(lines[i], "\t")[0], 10, 64)
+		b, _ := strconv.ParseInt(strings.Split(lines[j], "\t")[0], 10, 64)
+		return a < b
+	})
+	return lines, totals.entries()
+}
+
+// TestSearch checks that seeded soups give the same results and totals however many run at once,
+// and that a search that runs until interrupted finishes once stopped.
+func TestSearch(t *testing.T) {
+	p := golParams{
+		turns:        5000,
+		threads:      1,
+		imageWidth:   64,
+		imageHeight:  64,
+		rule:         conway,
+		maxPeriod:    64,
+		soup:         soupParams{generate: true, width: 16, height: 16, density: 0.5},
+		quiet:        true,
+		noFinalImage: true,
+	}
+	lines, totals := search(p, -2, 6, 1, nil)
+	if len(lines) != 5 {
+		t.Fatalf("Expected 5 soups from seeds -2 to 3 without 0, got %v", lines)
+	}
+	for _, line := range lines {
+		if fields := strings.Split(line, "\t"); fields[2] == "0" {
+			t.Errorf("Expected soup %s to stabilise within %d turns", fields[0], p.turns)
+		}
+	}
+	for _, parallel := range []int{1, 3} {
+		again, againTotals := search(p, -2, 6, parallel, nil)
+		if fmt.Sprint(again) != fmt.Sprint(lines) || fmt.Sprint(againTotals) != fmt.Sprint(totals) {
+			t.Errorf("Expected the same results with %d soups at once, got %v and %v, expected %v and %v",
+				parallel, again, againTotals, lines, totals)
+		}
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	done := make(chan []string)
+	go func() {
+		lines, _ := search(p, 1, 0, 2, stop)
+		done <- lines
+	}()
+	select {
+	case lines := <-done:
+		if len(lines) != 0 {
+			t.Errorf("Expected no soups after stopping, got %v", lines)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("Expected the search to finish after stopping")
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	p := func(turns, threads int, patterns ...placement) golParams {
+		return golParams{
+			turns:       turns,
+			threads:     threads,
+			imageWidth:  16,
+			imageHeight: 16,
+			emptyWorld:  true,
+			patterns:    patterns,
+		}
+	}
+	tests := []struct {
+		name     string
+		p        golParams
+		expected []placement
+	}{
+		{"block-10", p(10, 2, placement{name: "block", x: 7, y: 7}), []placement{{name: "block", x: 7, y: 7}}},
+		{"blinker-2", p(2, 4, placement{name: "blinker", x: 0, y: 15}), []placement{{name: "blinker", x: 0, y: 15}}},
+		{"beacon-2", p(2, 4, placement{name: "beacon", x: 14, y: 6}), []placement{{name: "beacon", x: 14, y: 6}}},
+		{"pulsar-3", p(3, 8, placement{name: "pulsar", x: 1, y: 2}), []placement{{name: "pulsar", x: 1, y: 2}}},
+		{"glider-4", p(4, 2, placement{name: "glider", x: 3, y: 5}), []placement{{name: "glider", x: 4, y: 6}}},
+		{"glider-64", p(64, 4, placement{name: "glider", x: 3, y: 5}), []placement{{name: "glider", x: 3, y: 5}}},
+		{"lwss-8", p(8, 4, placement{name: "lwss", x: 8, y: 2}), []placement{{name: "lwss", x: 4, y: 2}}},
+		{"two-gliders-8", p(8, 4, placement{name: "glider", x: 0, y: 0}, placement{name: "glider", x: 8, y: 8}),
+			[]placement{{name: "glider", x: 2, y: 2}, {name: "glider", x: 10, y: 10}}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			alive := gameOfLife(test.p, nil)
+			assertEqualBoard(t, alive, placed(t, test.p, test.expected...), test.p)
+		})
+	}
+}
+
+// TestScenes checks the world a scene starts with, and the world after some turns against referenceStep
+// and, where given, the cells expected by hand.
+func TestScenes(t *testing.T) {
+	tests := []struct {
+		scene    string
+		start    []cell
+		turns    int
+		expected []cell
+	}{
+		{"scenes/glider-corner.json",
+			[]cell{{11, 10}, {12, 11}, {10, 12}, {11, 12}, {12, 12}},
+			20, []cell{{14, 14}, {15, 14}, {14, 15}, {15, 15}}},
+		{"scenes/glider-collision.json",
+			[]cell{
+				// A glider heading down and right
+				{11, 10}, {12, 11}, {10, 12}, {11, 12}, {12, 12},
+				// Reflected, heading down and left
+				{31, 10}, {30, 11}, {30, 12}, {31, 12}, {32, 12},
+				// A turn on and rotated by half a turn, heading up and left
+				{31, 30}, {30, 31}, {31, 31}, {30, 32}, {32, 32},
+			},
+			60, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.scene, func(t *testing.T) {
+			s, err := loadScene(test.scene)
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := golParams{threads: 2, rule: conway, quiet: true, noFinalImage: true}
+			if err = s.apply(&p); err != nil {
+				t.Fatal(err)
+			}
+			if !assertEqualBoard(t, gameOfLife(p, nil), test.start, p) {
+				return
+			}
+
+			world := makeWorld(p.imageWidth, p.imageHeight)
+			for _, c := range test.start {
+				world[c.y][c.x] = 0xFF
+			}
+			for turn := 0; turn < test.turns; turn++ {
+				world = referenceStep(world, p.rule, p.topology)
+			}
+			p.turns = test.turns
+			alive := gameOfLife(p, nil)
+			if assertEqualBoard(t, alive, aliveCells(world), p) && test.expected != nil {
+				assertEqualBoard(t, alive, test.expected, p)
+			}
+		})
+	}
+}
+
+func TestImages(t *testing.T) {
+	heat, _ := parseScheme("heat")
+	p := golParams{
+		turns:       4,
+		threads:     2,
+		imageWidth:  20,
+		imageHeight: 20,
+		emptyWorld:  true,
+		patterns:    []placement{{name: "block", x: 3, y: 3}, {name: "blinker", x: 12, y: 12}},
+		image:       imageParams{format: formatPNG, scheme: heat, scale: 8, age: true},
+	}
+	gameOfLife(p, nil)
+
+	file, err := os.Open("out/" + p.outputName(p.turns) + ".png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	img, err := png.Decode(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		x, y     int
+		expected color.RGBA
+	}{
+		{"block", 3, 3, heat.alive(5)},
+		{"blinker centre", 13, 12, heat.alive(5)},
+		{"blinker end", 12, 12, heat.alive(1)},
+		{"dead", 0, 0, heat.dead},
+	}
+	for _, test := range tests {
+		// Sample the middle of the cell, away from the gridlines
+		got := color.RGBAModel.Convert(img.At(test.x*8+4, test.y*8+4))
+		if got != test.expected {
+			t.Errorf("%s: expected %v, got %v", test.name, test.expected, got)
+		}
+	}
+	if got := color.RGBAModel.Convert(img.At(3*8, 3*8)); got != heat.grid {
+		t.Errorf("gridline: expected %v, got %v", heat.grid, got)
+	}
+}
+
+// assertFrame checks that frame shows the alive cells of a world at the given scale.
+func assertFrame(t *testing.T, frame *image.Paletted, alive []cell, width, height, scale int) {
+	t.Helper()
+	if frame.Bounds() != image.Rect(0, 0, width*scale, height*scale) {
+		t.Fatalf("Expected a %dx%d frame, got %v", width*scale, height*scale, frame.Bounds())
+	}
+	isAlive := make(map[cell]bool)
+	for _, c := range alive {
+		isAlive[c] = true
+	}
+	for y := 0; y < height*scale; y++ {
+		for x := 0; x < width*scale; x++ {
+			r, _, _, _ := frame.At(x, y).RGBA()
+			if (r != 0) != isAlive[cell{x / scale, y / scale}] {
+				t.Fatalf("Expected pixel %d, %d to show cell %d, %d as alive: %v", x, y, x/scale, y/scale,
+					isAlive[cell{x / scale, y / scale}])
+			}
+		}
+	}
+}
+
+func TestRecord(t *testing.T) {
+	file, err := ioutil.TempFile("", "record*.gif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+	defer os.Remove(file.Name())
+
+	p := golParams{
+		turns:        8,
+		threads:      2,
+		imageWidth:   16,
+		imageHeight:  16,
+		emptyWorld:   true,
+		patterns:     []placement{{name: "glider", x: 1, y: 1}},
+		record:       recordParams{file: file.Name(), every: 2, scale: 2, delay: 7},
+		quiet:        true,
+		noFinalImage: true,
+	}
+	gameOfLife(p, nil)
+
+	f, err := os.Open(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	anim, err := gif.DecodeAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Turns 0, 2, 4, 6 and 8, in which the glider moves down and right by 2
+	if len(anim.Image) != 5 {
+		t.Fatalf("Expected 5 frames, got %d", len(anim.Image))
+	}
+	for i, delay := range anim.Delay {
+		if delay != 7 {
+			t.Errorf("Expected a delay of 7 for frame %d, got %d", i, delay)
+		}
+	}
+	assertFrame(t, anim.Image[0], []cell{{2, 1}, {3, 2}, {1, 3}, {2, 3}, {3, 3}}, 16, 16, 2)
+	assertFrame(t, anim.Image[4], []cell{{4, 3}, {5, 4}, {3, 5}, {4, 5}, {5, 5}}, 16, 16, 2)
+
+	// Once maxFrames are kept, every other frame is dropped and frames are recorded half as often.
+	// The cell alive in each world shows the turn it was recorded after.
+	p = golParams{imageWidth: 16, imageHeight: 16, record: recordParams{file: file.Name(), every: 1}}
+	rec := newRecorder(p)
+	rec.maxFrames = 4
+	for turn := 0; turn < 10; turn++ {
+		if rec.wants(turn) {
+			world := makeWorld(16, 16)
+			world[0][turn] = 0xFF
+			rec.capture(turn, world)
+		}
+	}
+	world := makeWorld(16, 16)
+	world[0][10] = 0xFF
+	rec.capture(10, world)
+
+	if rec.r.every != 4 || len(rec.anim.Image) != 4 {
+		t.Fatalf("Expected 4 frames recorded every 4 turns, got %d every %d", len(rec.anim.Image), rec.r.every)
+	}
+	for i, turn := range []int{0, 4, 8, 10} {
+		assertFrame(t, rec.anim.Image[i], []cell{{turn, 0}}, 16, 16, 1)
+	}
+
+	// Regions must have a size, and overlap the world
+	regions := []struct {
+		region string
+		valid  bool
+	}{
+		{"10,10,64,32", true},
+		{"-4,-4,8,8", true},
+		{"16,0,4,4", false},
+		{"0,-4,4,4", false},
+		{"10,10,-5,3", false},
+		{"10,10,5,0", false},
+		{"10,10,5", false},
+		{"10,10,5,x", false},
+	}
+	for _, test := range regions {
+		crop, err := parseRegion(test.region)
+		if err == nil {
+			err = recordParams{crop: crop}.checkCrop(16, 16)
+		}
+		if test.valid != (err == nil) {
+			t.Errorf("Region %q: expected valid %v, got %v", test.region, test.valid, err)
+		}
+	}
+}
+
+func TestSnapshots(t *testing.T) {
+	p := golParams{
+		turns:       40,
+		threads:     2,
+		imageWidth:  18,
+		imageHeight: 18,
+		emptyWorld:  true,
+		patterns:    []placement{{name: "glider", x: 2, y: 2}},
+		snapshots:   snapshotParams{turns: 10, keep: 2},
+	}
+	for turn := 0; turn <= p.turns; turn++ {
+		_ = os.Remove("out/" + p.snapshotName(turn) + ".pgm")
+	}
+
+	// With a buffer for every snapshot none are skipped, so the last keep are kept whatever the timing
+	defer func(buffers int) { ioBuffers = buffers }(ioBuffers)
+	ioBuffers = p.turns/p.snapshots.turns + 1
+	gameOfLife(p, nil)
+	var kept []int
+	for turn := 0; turn <= p.turns; turn++ {
+		if _, err := os.Stat("out/" + p.snapshotName(turn) + ".pgm"); err == nil {
+			kept = append(kept, turn)
+		}
+	}
+	if fmt.Sprint(kept) != "[30 40]" {
+		t.Fatalf("Expected snapshots of turns [30 40] kept, got %v", kept)
+	}
+
+	// Every snapshot is a copy of the world at its turn
+	world := makeWorld(p.imageWidth, p.imageHeight)
+	for _, c := range placed(t, p, p.patterns...) {
+		world[c.y][c.x] = 0xFF
+	}
+	for turn := 1; turn <= p.turns; turn++ {
+		world = referenceStep(world, conway, torus)
+		if turn == 30 || turn == 40 {
+			snapshot := readReferenceImage(t, "out/"+p.snapshotName(turn)+".pgm")
+			assertEqualBoard(t, aliveCells(snapshot), aliveCells(world), p)
+			_ = os.Remove("out/" + p.snapshotName(turn) + ".pgm")
+		}
+	}
+
+	// Retention doesn't depend on timing when writing snapshots directly
+	sw := snapshotWriter{p: p}
+	world = makeWorld(p.imageWidth, p.imageHeight)
+	for turn := 1; turn <= 5; turn++ {
+		sw.retain(writeImage(p, p.snapshotName(turn), world))
+	}
+	for turn := 1; turn <= 5; turn++ {
+		_, err := os.Stat("out/" + p.snapshotName(turn) + ".pgm")
+		if kept := turn > 3; kept != (err == nil) {
+			t.Errorf("Snapshot of turn %d: expected kept %v, got %v", turn, kept, err == nil)
+		}
+		_ = os.Remove("out/" + p.snapshotName(turn) + ".pgm")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	file, err := ioutil.TempFile("", "config*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	_, _ = file.WriteString(`{"turns": 10000000, "rule": "B36/S23", "headless": true}`)
+	_ = file.Close()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	turns := fs.Int("turns", 0, "")
+	rule := fs.String("rule", "B3/S23", "")
+	headless := fs.Bool("headless", false, "")
+	if err = fs.Parse([]string{"-rule", "B3/S23"}); err != nil {
+		t.Fatal(err)
+	}
+	if err = loadConfig(fs, file.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	if *turns != 10000000 || !*headless {
+		t.Errorf("Expected turns and headless from the file, got %d and %v", *turns, *headless)
+	}
+	if *rule != "B3/S23" {
+		t.Errorf("Expected the command line rule to override the file, got %s", *rule)
+	}
+}
+
+func TestControlAPI(t *testing.T) {
+	requests := make(chan controlRequest)
+	p := golParams{
+		turns:        1000000,
+		threads:      2,
+		imageWidth:   16,
+		imageHeight:  16,
+		emptyWorld:   true,
+		patterns:     []placement{{name: "glider", x: 3, y: 5}},
+		control:      requests,
+		noFinalImage: true,
+	}
+	api := controlAPI{p: p, requests: requests}
+	done := make(chan []cell)
+	go func() {
+		done <- gameOfLife(p, nil)
+	}()
+
+	call := func(handler http.HandlerFunc, method, url string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(method, url, nil)
+		r.Header.Set(controlHeader, "1")
+		handler(w, r)
+		return w
+	}
+	status := func(w *httptest.ResponseRecorder) controlReply {
+		var reply controlReply
+		if err := json.NewDecoder(w.Body).Decode(&reply); err != nil {
+			t.Fatal(err, w.Code)
+		}
+		return reply
+	}
+
+	paused := status(call(api.handle(controlPause, http.MethodPost), http.MethodPost, "/pause"))
+	if !paused.Paused {
+		t.Fatal("Expected the game to be paused")
+	}
+	if w := call(api.handle(controlStep, http.MethodPost), http.MethodGet, "/step"); w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Expected GET /step to be refused, got %d", w.Code)
+	}
+	world := func() []cell {
+		cells, err := parseRLE(call(api.world, http.MethodGet, "/world?format=rle").Body.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cells
+	}
+
+	// A glider returns to its shape every 4 turns, moved one cell down and right
+	var expected []cell
+	for _, c := range world() {
+		expected = append(expected, cell{(c.x + 1) % p.imageWidth, (c.y + 1) % p.imageHeight})
+	}
+	for i := 1; i <= 4; i++ {
+		stepped := status(call(api.handle(controlStep, http.MethodPost), http.MethodPost, "/step"))
+		if stepped.Turn != paused.Turn+i || stepped.Alive != 5 {
+			t.Errorf("Expected turn %d with 5 alive cells after stepping, got %+v", paused.Turn+i, stepped)
+		}
+	}
+	cells := world()
+	assertEqualBoard(t, cells, expected, p)
+
+	status(call(api.handle(controlQuit, http.MethodPost), http.MethodPost, "/quit"))
+	alive := <-done
+	assertEqualBoard(t, alive, cells, p)
+}
+
+// TestControlAPIListen checks that the HTTP API reports an address that is already in use instead of
+// failing once the game is running.
+func TestControlAPIListen(t *testing.T) {
+	p := golParams{imageWidth: 16, imageHeight: 16}
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := busy.Addr().String()
+	if _, _, err = startControlAPI(p, addr); err == nil {
+		t.Errorf("Expected an error serving on %s, which is in use", addr)
+	}
+
+	// Once the address is free the API is served from it
+	_ = busy.Close()
+	if _, _, err = startControlAPI(p, addr); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected the live view page from %s, got %s", addr, resp.Status)
+	}
+}
+
+// TestControlAPIOrigin checks that other pages open in a browser can't control the game, through the live view's
+// WebSocket or with a form posted to the API.
+func TestControlAPIOrigin(t *testing.T) {
+	api := controlAPI{p: golParams{imageWidth: 16, imageHeight: 16}}
+	upgrade := func(origin string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "http://localhost:8080/live", nil)
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		_, _ = upgradeWebSocket(w, r)
+		return w.Code
+	}
+	// A recorder can't be taken over, so upgrades that get past the origin check fail after it
+	for origin, expected := range map[string]int{
+		"":                      http.StatusInternalServerError,
+		"http://localhost:8080": http.StatusInternalServerError,
+		"http://evil.example":   http.StatusForbidden,
+		"http://localhost:9090": http.StatusForbidden,
+		"null":                  http.StatusForbidden,
+	} {
+		if code := upgrade(origin); code != expected {
+			t.Errorf("Upgrade from origin %q: expected %d, got %d", origin, expected, code)
+		}
+	}
+
+	// A form can be posted from any page, but can't set controlHeader
+	for _, command := range []controlCommand{controlQuit, controlSave, controlPause} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/quit", strings.NewReader("x=1"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		api.handle(command, http.MethodPost)(w, r)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected a form posted without %s to be refused, got %d", controlHeader, w.Code)
+		}
+	}
+}
+
+func TestLiveView(t *testing.T) {
+	requests := make(chan controlRequest)
+	p := golParams{
+		turns:        1000000,
+		threads:      2,
+		imageWidth:   16,
+		imageHeight:  16,
+		emptyWorld:   true,
+		patterns:     []placement{{name: "glider", x: 3, y: 5}},
+		control:      requests,
+		noFinalImage: true,
+	}
+	api := controlAPI{p: p, requests: requests, live: newLiveView(p)}
+	go api.live.run()
+	p.live = api.live.turns
+	done := make(chan []cell)
+	go func() {
+		done <- gameOfLife(p, nil)
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(api.serveLive))
+	defer server.Close()
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = conn.Write([]byte("GET /live HTTP/1.1\r\nHost: test\r\nOrigin: http://test\r\n" +
+		"Upgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	r := bufio.NewReader(conn)
+	response, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The accept key for this client key is given in RFC 6455
+	if response.StatusCode != http.StatusSwitchingProtocols ||
+		response.Header.Get("Sec-WebSocket-Accept") != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("Bad handshake: %d %v", response.StatusCode, response.Header)
+	}
+
+	// read returns the next message from the server, which is never masked
+	read := func() (byte, []byte) {
+		var header [2]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			t.Fatal(err)
+		}
+		n := int(header[1] & 0x7F)
+		if n == 126 {
+			var ext [2]byte
+			_, _ = io.ReadFull(r, ext[:])
+			n = int(binary.BigEndian.Uint16(ext[:]))
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			t.Fatal(err)
+		}
+		return header[0] & 0x0F, payload
+	}
+
+	// The first message is a whole frame, and every later binary message flips the 5 cells of
+	// the glider that changed or a whole frame if the client fell behind
+	var cells [16][16]bool
+	apply := func(msg []byte) int {
+		switch msg[0] {
+		case liveFrame:
+			for i := 0; i < 16*16; i++ {
+				cells[i/16][i%16] = msg[9+i/8]&(1<<uint(i%8)) != 0
+			}
+		case liveFlips:
+			n := int(binary.LittleEndian.Uint32(msg[5:]))
+			for i := 0; i < n; i++ {
+				x, y := binary.LittleEndian.Uint16(msg[9+4*i:]), binary.LittleEndian.Uint16(msg[11+4*i:])
+				cells[y][x] = !cells[y][x]
+			}
+		}
+		return int(binary.LittleEndian.Uint32(msg[1:]))
+	}
+	if opcode, msg := read(); opcode != wsBinary || msg[0] != liveFrame {
+		t.Fatalf("Expected a whole frame first, got opcode %d kind %d", opcode, msg[0])
+	} else {
+		apply(msg)
+	}
+
+	// Commands are sent as masked JSON text
+	command := []byte(`{"command":"pause"}`)
+	mask := []byte{1, 2, 3, 4}
+	frame := append([]byte{0x80 | wsText, 0x80 | byte(len(command))}, mask...)
+	for i, b := range command {
+		frame = append(frame, b^mask[i%4])
+	}
+	_, _ = conn.Write(frame)
+
+	turn := 0
+	for {
+		opcode, msg := read()
+		if opcode == wsBinary {
+			turn = apply(msg)
+			continue
+		}
+		var reply controlReply
+		if err = json.Unmarshal(msg, &reply); err != nil {
+			t.Fatal(err)
+		}
+		if !reply.Paused {
+			t.Fatalf("Expected to be paused, got %s", msg)
+		}
+		// Flips sent before the reply bring the view up to the paused turn
+		for turn < reply.Turn {
+			_, msg = read()
+			turn = apply(msg)
+		}
+		break
+	}
+
+	status(t, api, controlQuit)
+	alive := <-done
+	var viewed []cell
+	for y := range cells {
+		for x := range cells[y] {
+			if cells[y][x] {
+				viewed = append(viewed, cell{x, y})
+			}
+		}
+	}
+	assertEqualBoard(t, viewed, alive, p)
+}
+
+// status sends command to the distributor through api and returns the reply.
+func status(t *testing.T, api controlAPI, command controlCommand) controlReply {
+	reply, err := api.send(controlRequest{command: command})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return reply
+}
+
+func TestMetrics(t *testing.T) {
+	p := golParams{
+		turns:       10,
+		threads:     2,
+		imageWidth:  20,
+		imageHeight: 20,
+		emptyWorld:  true,
+		patterns:    []placement{{name: "glider", x: 3, y: 5}},
+		metrics:     newMetrics(2),
+	}
+	gameOfLife(p, nil)
+
+	var b strings.Builder
+	p.metrics.writeTo(&b)
+	for _, line := range []string{
+		"gol_turns_total 10",
+		"gol_alive_cells 5",
+		// The final PGM has a 13 byte header and a byte for each cell
+		"gol_io_bytes_written_total 413",
+		"gol_snapshot_duration_seconds_count 0",
+		`gol_worker_compute_seconds_total{worker="1"} `,
+		`gol_worker_halo_wait_seconds_total{worker="1"} `,
+		`gol_worker_idle_seconds_total{worker="1"} `,
+	} {
+		if !strings.Contains(b.String(), line) {
+			t.Errorf("Expected %q in the metrics:\n%s", line, b.String())
+		}
+	}
+	if p.metrics.workers[0].compute == 0 {
+		t.Error("Expected worker 0 to have measured its compute time")
+	}
+
+	b.Reset()
+	p.metrics.writeWorkerReport(&b, 10, []int{0, 10, 20})
+	lines := strings.Split(strings.TrimSpace(b.String()), "\n")
+	if len(lines) != 4 || !strings.Contains(lines[0], "over 10 turns") {
+		t.Fatalf("Expected a title, a header and a line per worker in the report:\n%s", b.String())
+	}
+	for w, line := range lines[2:] {
+		fields := strings.Fields(line)
+		if len(fields) != 6 || fields[0] != fmt.Sprint(w) || fields[1] != "10" {
+			t.Errorf("Expected worker %d with 10 rows, got %q", w, line)
+		}
+	}
+}
+
+// runWithin runs p, failing the test if it doesn't finish in time rather than waiting on a deadlock forever.
+func runWithin(t *testing.T, p golParams, timeout time.Duration) []cell {
+	t.Helper()
+	done := make(chan []cell, 1)
+	go func() {
+		done <- gameOfLife(p, nil)
+	}()
+	select {
+	case alive := <-done:
+		return alive
+	case <-time.After(timeout):
+		t.Fatalf("%dx%d with %d workers didn't finish in %v", p.imageWidth, p.imageHeight, p.threads, timeout)
+		return nil
+	}
+}
+
+// TestWorkers checks that the world is the same whatever the number of workers, including odd numbers,
+// a single worker and more workers than rows, with and without overlapping the halo exchange.
+func TestWorkers(t *testing.T) {
+	sizes := []struct{ width, height int }{
+		{1, 1}, {3, 1}, {1, 3}, {5, 2}, {7, 3}, {16, 16}, {17, 13}, {31, 64}, {64, 31},
+	}
+	for _, size := range sizes {
+		for _, topology := range []topology{torus, plane} {
+			t.Run(fmt.Sprintf("%dx%d-%v", size.width, size.height, topology), func(t *testing.T) {
+				p := golParams{
+					turns:        12,
+					threads:      1,
+					imageWidth:   size.width,
+					imageHeight:  size.height,
+					topology:     topology,
+					soup:         soupParams{generate: true, density: 0.4, seed: 44},
+					quiet:        true,
+					noFinalImage: true,
+				}
+				expected := runWithin(t, p, 10*time.Second)
+				for p.threads = 2; p.threads <= 64; p.threads++ {
+					for _, p.overlap = range []bool{false, true} {
+						alive := runWithin(t, p, 10*time.Second)
+						// Alive cells are listed row by row, so the same world gives the same list
+						if fmt.Sprint(alive) != fmt.Sprint(expected) {
+							t.Fatalf("%d workers (overlap %v) gave %d alive cells, 1 worker gave %d",
+								p.threads, p.overlap, len(alive), len(expected))
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// runStats runs p and returns the statistics of every turn and the final alive cells as a string to compare.
+func runStats(t *testing.T, p golParams) string {
+	t.Helper()
+	stats := make(chan turnStats, p.turns)
+	p.stats = stats
+	alive := runWithin(t, p, 10*time.Second)
+	close(stats)
+	var turns []turnStats
+	for ts := range stats {
+		turns = append(turns, ts)
+	}
+	return fmt.Sprint(turns, alive)
+}
+
+// TestHaloDepth checks that exchanging deeper halos gives the same statistics every turn as exchanging one row.
+func TestHaloDepth(t *testing.T) {
+	sizes := []struct{ width, height int }{
+		{1, 1}, {5, 2}, {7, 3}, {16, 16}, {17, 13}, {31, 64},
+	}
+	for _, size := range sizes {
+		for _, topology := range []topology{torus, cylinder, plane} {
+			t.Run(fmt.Sprintf("%dx%d-%v", size.width, size.height, topology), func(t *testing.T) {
+				for _, threads := range []int{1, 2, 3, 5, 8} {
+					p := golParams{
+						turns:        12,
+						threads:      threads,
+						imageWidth:   size.width,
+						imageHeight:  size.height,
+						topology:     topology,
+						soup:         soupParams{generate: true, density: 0.4, seed: 46},
+						quiet:        true,
+						noFinalImage: true,
+					}
+					expected := runStats(t, p)
+					// 12 turns isn't a multiple of 5, so the last batch is shorter
+					for _, p.haloDepth = range []int{2, 3, 5, 16} {
+						if got := runStats(t, p); got != expected {
+							t.Fatalf("%d workers with halo depth %d gave\n%s\nexpected\n%s", threads, p.haloDepth, got, expected)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestFreeRun checks that free running workers give the same statistics every turn as workers that wait for the
+// distributor every turn.
+func TestFreeRun(t *testing.T) {
+	sizes := []struct{ width, height int }{
+		{1, 1}, {5, 2}, {7, 3}, {16, 16}, {17, 13}, {31, 64},
+	}
+	for _, size := range sizes {
+		for _, topology := range []topology{torus, plane} {
+			t.Run(fmt.Sprintf("%dx%d-%v", size.width, size.height, topology), func(t *testing.T) {
+				for _, threads := range []int{1, 2, 3, 5, 8} {
+					p := golParams{
+						turns:        40,
+						threads:      threads,
+						imageWidth:   size.width,
+						imageHeight:  size.height,
+						topology:     topology,
+						soup:         soupParams{generate: true, density: 0.4, seed: 47},
+						quiet:        true,
+						noFinalImage: true,
+					}
+					expected := runStats(t, p)
+					p.freeRun = true
+					for _, p.overlap = range []bool{false, true} {
+						if got := runStats(t, p); got != expected {
+							t.Fatalf("%d free running workers gave\n%s\nexpected\n%s", threads, got, expected)
+						}
+					}
+				}
+			})
+		}
+	}
+}
+
+// TestFreeRunControl checks that the world gathered from free running workers when they are paused
+// is the world at the turn they were paused at.
+func TestFreeRunControl(t *testing.T) {
+	control := make(chan controlRequest)
+	p := golParams{
+		turns:        1000000,
+		threads:      3,
+		imageWidth:   32,
+		imageHeight:  32,
+		soup:         soupParams{generate: true, density: 0.4, seed: 47},
+		freeRun:      true,
+		control:      control,
+		quiet:        true,
+		noFinalImage: true,
+	}
+	done := make(chan []cell, 1)
+	go func() {
+		done <- gameOfLife(p, nil)
+	}()
+	send := func(command controlCommand) controlReply {
+		reply := make(chan controlReply, 1)
+		control <- controlRequest{command: command, reply: reply}
+		return <-reply
+	}
+	expected := func(turns int) []cell {
+		q := p
+		q.turns, q.freeRun, q.control = turns, false, nil
+		return runWithin(t, q, 10*time.Second)
+	}
+
+	for i := 0; i < 3; i++ {
+		paused := send(controlPause)
+		reply := send(controlWorld)
+		if reply.Turn != paused.Turn {
+			t.Fatalf("Paused at turn %d but the world is from turn %d", paused.Turn, reply.Turn)
+		}
+		if got, want := aliveCells(reply.world), expected(reply.Turn); fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("The world at turn %d has %d alive cells, expected %d", reply.Turn, len(got), len(want))
+		}
+		send(controlResume)
+	}
+
+	quit := send(controlQuit)
+	alive := <-done
+	if want := expected(quit.Turn); fmt.Sprint(alive) != fmt.Sprint(want) {
+		t.Fatalf("Quit at turn %d with %d alive cells, expected %d", quit.Turn, len(alive), len(want))
+	}
+}
+
+// TestFreeRunLimit checks that halted workers stop at the furthest turn any of them has started,
+// and that a worker still finishing a halted batch doesn't run into the next one.
+func TestFreeRunLimit(t *testing.T) {
+	var l freeRunLimit
+	l.start(100)
+	batch := l.current()
+	for turn := 1; turn <= 5; turn++ {
+		if !l.next(batch, turn) {
+			t.Fatalf("Expected turn %d to start", turn)
+		}
+	}
+	if target := l.halt(); target != 5 {
+		t.Fatalf("Expected to halt at turn 5, the furthest started, got %d", target)
+	}
+	if !l.next(batch, 4) || !l.next(batch, 5) {
+		t.Error("Expected workers behind to catch up to turn 5 after halting")
+	}
+	if l.next(batch, 6) {
+		t.Error("Expected turn 6 not to start after halting at turn 5")
+	}
+
+	l.start(10)
+	if l.next(batch, 6) {
+		t.Error("Expected a worker of the halted batch not to start turn 6 of the next batch")
+	}
+	if !l.next(l.current(), 6) {
+		t.Error("Expected turn 6 to start in the next batch")
+	}
+}
+
+// TestKernels checks that every kernel flips the same cells as the cells kernel, in strips and parts of strips
+// of any size, for several rules and topologies, and that games run with each kernel are the same.
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		rule     string
+		expected string // The rule in B/S notation, or "" if the rule is invalid
+	}{
+		{"B3/S23", "B3/S23"},
+		{"S23/B3", "B3/S23"},
+		{"23/3", "B3/S23"},
+		{"b36/s23", "B36/S23"},
+		{" s23/b36 ", "B36/S23"},
+		{"B0/S", "B0/S"},
+		{"/3", "B3/S"},
+		{"B3", ""},
+		{"B3/S23/S", ""},
+		{"B9/S23", ""},
+		{"B3/S2x", ""},
+		{"X3/S23", ""},
+		{"B3/B3", ""},
+		{"B3/23", ""},
+	}
+	for _, test := range tests {
+		r, err := parseRule(test.rule)
+		switch {
+		case test.expected == "" && err == nil:
+			t.Errorf("Expected %q to be rejected, got %v", test.rule, r)
+		case test.expected != "" && err != nil:
+			t.Errorf("Expected %q to be %s, got %v", test.rule, test.expected, err)
+		case test.expected != "" && r.String() != test.expected:
+			t.Errorf("Expected %q to be %s, got %v", test.rule, test.expected, r)
+		}
+	}
+}
+
+func TestKernels(t *testing.T) {
+	random := rand.New(rand.NewSource(48))
+	randomRows := func(width, height int) [][]byte {
+		rows := makeWorld(width, height)
+		for y := range rows {
+			for x := range rows[y] {
+				if random.Intn(2) == 0 {
+					rows[y][x] = 0xFF
+				}
+			}
+		}
+		return rows
+	}
+	sorted := func(cells []cell) string {
+		sort.Slice(cells, func(i, j int) bool {
+			return cells[i].y < cells[j].y || cells[i].y == cells[j].y && cells[i].x < cells[j].x
+		})
+		return fmt.Sprint(cells)
+	}
+
+	for _, name := range []string{"B3/S23", "B36/S23", "B2/S", "B0/S8", "B1357/S1357"} {
+		r, err := parseRule(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, topology := range []topology{torus, plane} {
+			for width := 1; width <= 9; width++ {
+				for height := 1; height <= 6; height++ {
+					p := golParams{imageWidth: width, rule: r, topology: topology}
+					source := randomRows(width, height)
+					halos := randomRows(width, 2)
+					for startY := 0; startY < height; startY++ {
+						for endY := startY; endY <= height; endY++ {
+							expected := sorted(stepCells(p, source, halos[0], halos[1], startY, endY, nil))
+							for k := range kernelNames {
+								p.kernel = kernel(k)
+								got := sorted(stepRows(p, source, halos[0], halos[1], startY, endY, nil))
+								if got != expected {
+									t.Fatalf("%v kernel with %v on a %v flipped rows %d to %d of a %dx%d strip as\n%s\nexpected\n%s",
+										p.kernel, r, topology, startY, endY, width, height, got, expected)
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	for _, size := range []struct{ width, height int }{{16, 16}, {17, 13}} {
+		p := golParams{
+			turns:        30,
+			threads:      3,
+			imageWidth:   size.width,
+			imageHeight:  size.height,
+			soup:         soupParams{generate: true, density: 0.4, seed: 48},
+			quiet:        true,
+			noFinalImage: true,
+		}
+		expected := runStats(t, p)
+		for k := range kernelNames {
+			p.kernel = kernel(k)
+			for _, p.haloDepth = range []int{1, 3} {
+				if got := runStats(t, p); got != expected {
+					t.Fatalf("%v kernel with halo depth %d gave\n%s\nexpected\n%s", p.kernel, p.haloDepth, got, expected)
+				}
+			}
+		}
+	}
+}
+
+// referenceStep returns the world after one turn of r on a topology, counting the neighbours of every cell
+// from scratch. It is deliberately simple so that the workers can be checked against it.
+func referenceStep(world [][]byte, r rule, topology topology) [][]byte {
+	height, width := len(world), len(world[0])
+	alive := func(x, y int) bool {
+		if x < 0 || x >= width {
+			if !topology.wrapsX() {
+				return false
+			}
+			x = (x + width) % width
+		}
+		if y < 0 || y >= height {
+			if !topology.wrapsY() {
+				return false
+			}
+			y = (y + height) % height
+		}
+		return world[y][x] != 0
+	}
+
+	next := makeWorld(width, height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			n := 0
+			for dy := -1; dy <= 1; dy++ {
+				for dx := -1; dx <= 1; dx++ {
+					if (dx != 0 || dy != 0) && alive(x+dx, y+dy) {
+						n++
+					}
+				}
+			}
+			if alive(x, y) && r.survives(n) || !alive(x, y) && r.born(n) {
+				next[y][x] = 0xFF
+			}
+		}
+	}
+	return next
+}
+
+// readReferenceImage reads a PGM image into a world, independently of readPgmImage.
+func readReferenceImage(t *testing.T, filename string) [][]byte {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var magic string
+	var width, height int
+	if _, err := fmt.Sscan(string(data), &magic, &width, &height); err != nil || magic != "P5" {
+		t.Fatalf("%s isn't a PGM image", filename)
+	}
+	pixels := data[len(data)-width*height:]
+	world := makeWorld(width, height)
+	for y := range world {
+		copy(world[y], pixels[y*width:(y+1)*width])
+	}
+	return world
+}
+
+// referenceView is the size of the part of a large world shown when it differs from the reference world.
+const referenceView = 16
+
+// referenceFail reports that the alive cells given by the workers differ from the expected cells of the reference
+// world. Worlds larger than referenceView are cropped around the first cell that differs.
+func referenceFail(t *testing.T, p golParams, given, expected []cell) {
+	t.Helper()
+	// Both lists are in row order, so the first cell that differs is the first one not in both
+	i := 0
+	for i < len(given) && i < len(expected) && given[i] == expected[i] {
+		i++
+	}
+	var first cell
+	switch {
+	case i == len(given):
+		first = expected[i]
+	case i == len(expected):
+		first = given[i]
+	case given[i].y < expected[i].y || given[i].y == expected[i].y && given[i].x < expected[i].x:
+		first = given[i]
+	default:
+		first = expected[i]
+	}
+
+	viewX, viewY, viewWidth, viewHeight := 0, 0, p.imageWidth, p.imageHeight
+	// crop returns the cells inside the view, relative to its top left corner
+	crop := func(cells []cell) []cell {
+		var cropped []cell
+		for _, c := range cells {
+			if c.x >= viewX && c.x < viewX+viewWidth && c.y >= viewY && c.y < viewY+viewHeight {
+				cropped = append(cropped, cell{c.x - viewX, c.y - viewY})
+			}
+		}
+		return cropped
+	}
+	if viewWidth > referenceView {
+		viewWidth = referenceView
+		viewX = first.x - viewWidth/2
+		if viewX < 0 {
+			viewX = 0
+		} else if viewX > p.imageWidth-viewWidth {
+			viewX = p.imageWidth - viewWidth
+		}
+	}
+	if viewHeight > referenceView {
+		viewHeight = referenceView
+		viewY = first.y - viewHeight/2
+		if viewY < 0 {
+			viewY = 0
+		} else if viewY > p.imageHeight-viewHeight {
+			viewY = p.imageHeight - viewHeight
+		}
+	}
+
+	t.Errorf("%s with %d workers first differs from the reference at turn %d, at cell %d,%d, "+
+		"with %d alive cells instead of %d\n  Cells %d,%d to %d,%d:\n%s",
+		p.inputFile, p.threads, p.turns, first.x, first.y, len(given), len(expected),
+		viewX, viewY, viewX+viewWidth-1, viewY+viewHeight-1,
+		aliveCellsToString(crop(given), crop(expected), viewWidth, viewHeight))
+}
+
+// TestReference runs every image in images/ with several numbers of workers and checks the alive cells
+// after many numbers of turns against referenceStep. When they differ it looks for the first turn they differ at.
+func TestReference(t *testing.T) {
+	files, err := filepath.Glob("images/*.pgm")
+	if err != nil || len(files) == 0 {
+		t.Fatal("no images found in images/")
+	}
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			world := readReferenceImage(t, file)
+			width, height := len(world[0]), len(world)
+			checkTurns := []int{0, 1, 2, 3, 4, 5, 8, 13, 21, 34, 55, 89, 100}
+			threadCounts := []int{1, 2, 3, 4, 7, 16}
+			// Large images take too long to run this many times, so they are checked less
+			if width*height > 64*64 {
+				if testing.Short() {
+					t.Skip("skipping large image in short mode")
+				}
+				checkTurns = []int{0, 1, 2, 3, 5, 8, 13, 21}
+				threadCounts = []int{1, 3, 16}
+			}
+			lastTurn := checkTurns[len(checkTurns)-1]
+
+			// The alive cells of the reference world after every turn
+			expected := [][]cell{aliveCells(world)}
+			for turn := 1; turn <= lastTurn; turn++ {
+				world = referenceStep(world, conway, torus)
+				expected = append(expected, aliveCells(world))
+			}
+
+			for _, threads := range threadCounts {
+				p := golParams{
+					threads:      threads,
+					imageWidth:   width,
+					imageHeight:  height,
+					inputFile:    file,
+					quiet:        true,
+					noFinalImage: true,
+				}
+				// The last turn checked that was the same as the reference
+				same := -1
+				for _, turns := range checkTurns {
+					p.turns = turns
+					alive := runWithin(t, p, 30*time.Second)
+					if fmt.Sprint(alive) == fmt.Sprint(expected[turns]) {
+						same = turns
+						continue
+					}
+					for p.turns = same + 1; p.turns < turns; p.turns++ {
+						if earlier := runWithin(t, p, 30*time.Second); fmt.Sprint(earlier) != fmt.Sprint(expected[p.turns]) {
+							alive = earlier
+							break
+						}
+					}
+					referenceFail(t, p, alive, expected[p.turns])
+					break
+				}
+			}
+		})
+	}
+}
+
 const benchLength = 1000
 
 func Benchmark(b *testing.B) {
@@ -498,3 +2180,52 @@ func assertEqualBoard(t *testing.T, given, expected []cell, p golParams) bool {
 
 	return true
 }
+
+// BenchmarkOverlap compares workers that exchange halos before computing with workers that overlap the two.
+// It is kept apart from Benchmark so that the comparison script only compares against the baseline table.
+func BenchmarkOverlap(b *testing.B) {
+	for _, size := range []int{16, 64, 128, 256, 512} {
+		for _, threads := range []int{2, 4, 8} {
+			for _, overlap := range []bool{false, true} {
+				p := golParams{
+					turns:        benchLength,
+					threads:      threads,
+					imageWidth:   size,
+					imageHeight:  size,
+					overlap:      overlap,
+					quiet:        true,
+					noFinalImage: true,
+				}
+				b.Run(fmt.Sprintf("%dx%dx%d/overlap=%v", size, size, threads, overlap), func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						gameOfLife(p, nil)
+					}
+				})
+			}
+		}
+	}
+}
+
+// BenchmarkKernels times one turn of a whole world with each kernel, without any workers.
+func BenchmarkKernels(b *testing.B) {
+	random := rand.New(rand.NewSource(48))
+	for _, size := range []int{64, 512} {
+		world := makeWorld(size, size)
+		for y := range world {
+			for x := range world[y] {
+				if random.Intn(3) == 0 {
+					world[y][x] = 0xFF
+				}
+			}
+		}
+		for k := range kernelNames {
+			p := golParams{imageWidth: size, imageHeight: size, rule: conway, kernel: kernel(k)}
+			b.Run(fmt.Sprintf("%dx%d/%v", size, size, p.kernel), func(b *testing.B) {
+				var marked []cell
+				for i := 0; i < b.N; i++ {
+					marked = stepRows(p, world, world[size-1], world[0], 0, size, marked[:0])
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+)
+
+// workerMetrics is the time one worker spent on each part of its turns, in nanoseconds.
+// The fields are updated atomically by the worker and read by the metrics endpoint.
+type workerMetrics struct {
+	compute  int64 // Applying the rule to the strip
+	haloWait int64 // Sending and receiving halos, including waiting for neighbours
+	idle     int64 // Waiting for the distributor to start the next turn
+}
+
+// start returns the time a worker starts a turn, or the zero time if wm is nil.
+func (wm *workerMetrics) start() time.Time {
+	if wm == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+// idleDone adds the time since start to the idle time and returns the current time.
+func (wm *workerMetrics) idleDone(start time.Time) time.Time {
+	if wm == nil {
+		return start
+	}
+	return lap(&wm.idle, start)
+}
+
+// haloDone adds the time since start to the halo wait and returns the current time.
+func (wm *workerMetrics) haloDone(start time.Time) time.Time {
+	if wm == nil {
+		return start
+	}
+	return lap(&wm.haloWait, start)
+}
+
+// computeDone adds the time since start to the compute time and returns the current time.
+func (wm *workerMetrics) computeDone(start time.Time) time.Time {
+	if wm == nil {
+		return start
+	}
+	return lap(&wm.compute, start)
+}
+
+// lap adds the time since start to the nanoseconds in counter and returns the current time.
+func lap(counter *int64, start time.Time) time.Time {
+	now := time.Now()
+	atomic.AddInt64(counter, int64(now.Sub(start)))
+	return now
+}
+
+// metrics instruments a running game for the metrics endpoint of the HTTP API.
+// Counters are updated atomically so that they can be read while the game runs.
+type metrics struct {
+	turns        int64
+	alive        int64
+	turnsPerSec  int64 // Stored as the bits of a float64
+	turnWait     int64 // Nanoseconds the distributor spent waiting for workers to finish turns
+	ioBytes      int64
+	snapshots    int64
+	snapshotTime int64 // Nanoseconds spent writing snapshots
+
+	workers []workerMetrics
+
+	// Used by the distributor to measure turns per second over at least rateInterval
+	rateTurns int
+	rateStart time.Time
+}
+
+// rateInterval is the shortest time turns per second are measured over.
+const rateInterval = time.Second
+
+func newMetrics(threads int) *metrics {
+	return &metrics{workers: make([]workerMetrics, threads), rateStart: time.Now()}
+}
+
+// worker returns the metrics of worker t, or nil if m is nil.
+func (m *metrics) worker(t int) *workerMetrics {
+	if m == nil {
+		return nil
+	}
+	return &m.workers[t]
+}
+
+// turnDone records that the distributor finished a turn, after waiting wait for the workers.
+func (m *metrics) turnDone(turns, alive int, wait time.Duration) {
+	atomic.StoreInt64(&m.turns, int64(turns))
+	atomic.StoreInt64(&m.alive, int64(alive))
+	atomic.AddInt64(&m.turnWait, int64(wait))
+
+	if elapsed := time.Since(m.rateStart); elapsed >= rateInterval {
+		rate := float64(turns-m.rateTurns) / elapsed.Seconds()
+		atomic.StoreInt64(&m.turnsPerSec, int64(math.Float64bits(rate)))
+		m.rateTurns, m.rateStart = turns, time.Now()
+	}
+}
+
+// countingWriter counts the bytes written through it into the metrics.
+type countingWriter struct {
+	w io.Writer
+	m *metrics
+}
+
+func (cw countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	atomic.AddInt64(&cw.m.ioBytes, int64(n))
+	return n, err
+}
+
+// countWrites returns w, counting the bytes written to it if m isn't nil.
+func (m *metrics) countWrites(w io.Writer) io.Writer {
+	if m == nil {
+		return w
+	}
+	return countingWriter{w: w, m: m}
+}
+
+// snapshotWritten records the time taken to write a snapshot.
+func (m *metrics) snapshotWritten(d time.Duration) {
+	atomic.AddInt64(&m.snapshots, 1)
+	atomic.AddInt64(&m.snapshotTime, int64(d))
+}
+
+// writeTo writes the metrics in the Prometheus text format.
+//noinspection GoUnhandledErrorResult
+func (m *metrics) writeTo(w io.Writer) {
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+	value := func(name string, v float64) {
+		fmt.Fprintln(w, name, strconv.FormatFloat(v, 'g', -1, 64))
+	}
+	seconds := func(nanos *int64) float64 {
+		return time.Duration(atomic.LoadInt64(nanos)).Seconds()
+	}
+
+	metric("gol_turns_total", "counter", "Turns completed.")
+	value("gol_turns_total", float64(atomic.LoadInt64(&m.turns)))
+	metric("gol_turns_per_second", "gauge", "Turns completed per second, measured over at least a second.")
+	value("gol_turns_per_second", math.Float64frombits(uint64(atomic.LoadInt64(&m.turnsPerSec))))
+	metric("gol_alive_cells", "gauge", "Alive cells after the last turn.")
+	value("gol_alive_cells", float64(atomic.LoadInt64(&m.alive)))
+	metric("gol_turn_wait_seconds_total", "counter", "Time the distributor spent waiting for workers to finish turns.")
+	value("gol_turn_wait_seconds_total", seconds(&m.turnWait))
+
+	metric("gol_worker_compute_seconds_total", "counter", "Time each worker spent applying the rule to its strip.")
+	for t := range m.workers {
+		value(`gol_worker_compute_seconds_total{worker="`+strconv.Itoa(t)+`"}`, seconds(&m.workers[t].compute))
+	}
+	metric("gol_worker_halo_wait_seconds_total", "counter", "Time each worker spent exchanging halos with its neighbours.")
+	for t := range m.workers {
+		value(`gol_worker_halo_wait_seconds_total{worker="`+strconv.Itoa(t)+`"}`, seconds(&m.workers[t].haloWait))
+	}
+	metric("gol_worker_idle_seconds_total", "counter", "Time each worker spent waiting for the distributor to start a turn.")
+	for t := range m.workers {
+		value(`gol_worker_idle_seconds_total{worker="`+strconv.Itoa(t)+`"}`, seconds(&m.workers[t].idle))
+	}
+
+	metric("gol_io_bytes_written_total", "counter", "Bytes of images written by the io goroutine.")
+	value("gol_io_bytes_written_total", float64(atomic.LoadInt64(&m.ioBytes)))
+	metric("gol_snapshot_duration_seconds", "summary", "Time taken to write each snapshot.")
+	value("gol_snapshot_duration_seconds_sum", seconds(&m.snapshotTime))
+	value("gol_snapshot_duration_seconds_count", float64(atomic.LoadInt64(&m.snapshots)))
+}
+
+// writeWorkerReport writes the average time each worker spent computing, exchanging halos and idle per turn,
+// with the rows of its strip from yParams, so that strips can be balanced.
+//noinspection GoUnhandledErrorResult
+func (m *metrics) writeWorkerReport(w io.Writer, turns int, yParams []int) {
+	fmt.Fprintf(w, "Time per turn for each worker over %d turns:\n", turns)
+	if turns == 0 {
+		return
+	}
+	perTurn := func(nanos *int64) time.Duration {
+		return time.Duration(atomic.LoadInt64(nanos) / int64(turns)).Round(100 * time.Nanosecond)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "worker\trows\tcompute\thalo\tidle\tcompute %\t")
+	for t := range m.workers {
+		wm := &m.workers[t]
+		compute, halo, idle := perTurn(&wm.compute), perTurn(&wm.haloWait), perTurn(&wm.idle)
+		share := 0.0
+		if total := compute + halo + idle; total > 0 {
+			share = 100 * float64(compute) / float64(total)
+		}
+		fmt.Fprintf(tw, "%d\t%d\t%v\t%v\t%v\t%.1f\t\n", t, yParams[t+1]-yParams[t], compute, halo, idle, share)
+	}
+	tw.Flush()
+}
+
+// serveMetrics serves the metrics of the game for Prometheus.
+func (m *metrics) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	m.writeTo(w)
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// patternLibrary holds classic patterns in run length encoded (RLE) form, keyed by name.
+var patternLibrary = map[string]string{
+	// Still lifes and oscillators
+	"block":          "2o$2o!",
+	"beehive":        "b2o$o2bo$b2o!",
+	"blinker":        "3o!",
+	"toad":           "b3o$3o!",
+	"beacon":         "2o2b$2o2b$2b2o$2b2o!",
+	"pulsar":         "2b3o3b3o2b2$o4bobo4bo$o4bobo4bo$o4bobo4bo$2b3o3b3o2b2$2b3o3b3o2b$o4bobo4bo$o4bobo4bo$o4bobo4bo2$2b3o3b3o!",
+	"pentadecathlon": "2bo4bo2b$2ob4ob2o$2bo4bo!",
+
+	// Spaceships
+	"glider": "bob$2bo$3o!",
+	"lwss":   "bo2bo$o4b$o3bo$4o!",
+	"mwss":   "3bo2b$bo3bo$o5b$o4bo$5o!",
+	"hwss":   "3b2o2b$bo4bo$o6b$o5bo$6o!",
+
+	// Guns
+	"gosper": "24bo$22bobo$12b2o6b2o12b2o$11bo3bo4b2o12b2o$2o8bo5bo3b2o$2o8bo3bob2o4bobo$10bo5bo7bo$11bo3bo$12b2o!",
+	"simkin": "2o5b2o$2o5b2o2$4b2o$4b2o5$22b2ob2o$21bo5bo$21bo6bo2b2o$21b3o3bo3b2o$26bo4$20b2o$20bo$21b3o$23bo!",
+
+	// Methuselahs
+	"r-pentomino": "b2o$2o$bo!",
+	"acorn":       "bo5b$3bo3b$2o2b3o!",
+	"diehard":     "6bob$2o6b$bo3b3o!",
+	"rabbits":     "o3b3o$3o2bo$bo!",
+}
+
+// patternNames returns the names of every pattern in the library, sorted.
+func patternNames() []string {
+	var names []string
+	for name := range patternLibrary {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupPattern returns the cells of the named pattern, with its top left corner at 0,0.
+func lookupPattern(name string) ([]cell, error) {
+	rle, ok := patternLibrary[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown pattern %q, expected one of %s", name, strings.Join(patternNames(), ", "))
+	}
+	return parseRLE(rle)
+}
+
+// parseRLE decodes a pattern in run length encoded form. Comment lines starting with '#' and the
+// 'x = ..., y = ...' header line are skipped.
+func parseRLE(rle string) ([]cell, error) {
+	var cells []cell
+	x, y, run := 0, 0, 0
+
+	for _, line := range strings.Split(rle, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") || strings.HasPrefix(line, "x") {
+			continue
+		}
+
+		for _, ch := range line {
+			switch {
+			case unicode.IsDigit(ch):
+				run = run*10 + int(ch-'0')
+				continue
+			case unicode.IsSpace(ch):
+				continue
+			}
+
+			n := run
+			if n == 0 {
+				n = 1
+			}
+			run = 0
+
+			switch ch {
+			case 'b', '.':
+				x += n
+			case 'o', 'A':
+				for i := 0; i < n; i++ {
+					cells = append(cells, cell{x + i, y})
+				}
+				x += n
+			case '$':
+				x = 0
+				y += n
+			case '!':
+				return cells, nil
+			default:
+				return nil, fmt.Errorf("unexpected %q in RLE", ch)
+			}
+		}
+	}
+	return cells, nil
+}
+
+// encodeRLE encodes the alive cells of world in run length encoded form, with a header giving its size and rule.
+func encodeRLE(world [][]byte, r rule) string {
+	var b strings.Builder
+	width := 0
+	if len(world) > 0 {
+		width = len(world[0])
+	}
+	b.WriteString(fmt.Sprintf("x = %d, y = %d, rule = %s\n", width, len(world), r))
+
+	// Runs of dead cells at the end of rows, and of empty rows, are left out
+	var line strings.Builder
+	run := func(n int, ch byte) {
+		if n > 1 {
+			line.WriteString(strconv.Itoa(n))
+		}
+		if n > 0 {
+			line.WriteByte(ch)
+		}
+	}
+	emptyRows, first := 0, true
+	for _, row := range world {
+		end := len(row)
+		for end > 0 && row[end-1] == 0 {
+			end--
+		}
+		if end == 0 {
+			emptyRows++
+			continue
+		}
+		// Rows above the first alive row need one '$' each, and later rows one more to end the last row
+		if first {
+			run(emptyRows, '$')
+		} else {
+			run(emptyRows+1, '$')
+		}
+		emptyRows, first = 0, false
+
+		for x := 0; x < end; {
+			n := 1
+			for x+n < end && (row[x+n] != 0) == (row[x] != 0) {
+				n++
+			}
+			if row[x] != 0 {
+				run(n, 'o')
+			} else {
+				run(n, 'b')
+			}
+			x += n
+		}
+	}
+	line.WriteByte('!')
+
+	// Lines of RLE are kept to at most 70 characters
+	s := line.String()
+	for len(s) > 70 {
+		cut := 70
+		for cut > 0 && s[cut-1] >= '0' && s[cut-1] <= '9' {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteByte('\n')
+		s = s[cut:]
+	}
+	b.WriteString(s)
+	b.WriteByte('\n')
+	return b.String()
+}
+
+// placement puts a named pattern, or one given in RLE form, with the top left corner of its
+// bounding box at x, y.
+// The pattern is first advanced by phase generations, then reflected left to right if reflect is set,
+// then rotated clockwise by rotate quarter turns.
+type placement struct {
+	name string
+	x, y int
+
+	rle     string
+	phase   int
+	reflect bool
+	rotate  int
+}
+
+// parsePosition converts a position such as "10,10" into x and y.
+func parsePosition(s string) (int, int, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid position %q, expected x,y", s)
+	}
+	x, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid position %q, expected x,y", s)
+	}
+	y, err := strconv.Atoi(strings.TrimSpace(parts[1]))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid position %q, expected x,y", s)
+	}
+	return x, y, nil
+}
+
+// cells returns the cells of the placed pattern under the rule in p.
+// Cells outside the world wrap around, or are dropped if the topology doesn't wrap.
+func (pl placement) cells(p golParams) ([]cell, error) {
+	var pattern []cell
+	var err error
+	if pl.rle != "" {
+		pattern, err = parseRLE(pl.rle)
+	} else {
+		pattern, err = lookupPattern(pl.name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := p.rule
+	if r == (rule{}) {
+		r = conway
+	}
+	for i := 0; i < pl.phase; i++ {
+		pattern = evolveRule(pattern, r)
+	}
+
+	// Transformations of the square (see cell.transform) are a reflection followed by rotations
+	t := ((pl.rotate % 4) + 4) % 4
+	if pl.reflect {
+		t |= 4
+	}
+	for i, c := range pattern {
+		pattern[i] = c.transform(t)
+	}
+	pattern, _ = normalise(pattern)
+
+	cells := make([]cell, 0, len(pattern))
+	for _, c := range pattern {
+		x, y := c.x+pl.x, c.y+pl.y
+		if (!p.topology.wrapsX() && (x < 0 || x >= p.imageWidth)) ||
+			(!p.topology.wrapsY() && (y < 0 || y >= p.imageHeight)) {
+			continue
+		}
+		cells = append(cells, cell{(x%p.imageWidth + p.imageWidth) % p.imageWidth,
+			(y%p.imageHeight + p.imageHeight) % p.imageHeight})
+	}
+	return cells, nil
+}
+
+// stampPatterns sets the cells of every pattern in p.patterns alive in world.
+func stampPatterns(p golParams, world [][]byte) {
+	for _, pl := range p.patterns {
+		cells, err := pl.cells(p)
+		check(err)
+		for _, c := range cells {
+			world[c.y][c.x] = 0xFF
+		}
+	}
+}
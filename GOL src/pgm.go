@@ -1,11 +1,14 @@
 package main
 
 import (
-	"fmt"
+	"bufio"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func check(e error) {
@@ -14,52 +17,54 @@ func check(e error) {
 	}
 }
 
-// writePgmImage receives an array of bytes and writes it to a pgm file.
-func writePgmImage(p golParams, i ioChans) {
-	_ = os.Mkdir("out", os.ModePerm)
+// writePgm writes world to <filename>.pgm in the output directory and returns the path written.
+func writePgm(p golParams, filename string, world [][]byte) string {
+	_ = os.MkdirAll(p.outDir(), os.ModePerm)
 
-	filename := <-i.distributor.filename
-	file, ioError := os.Create("out/" + filename + ".pgm")
+	path := filepath.Join(p.outDir(), filename+".pgm")
+	file, ioError := os.Create(path)
 	check(ioError)
 	defer file.Close()
 
-	_, _ = file.WriteString("P5\n")
-	//_, _ = file.WriteString("# PGM file writer by pnmmodules (https://github.com/owainkenwayucl/pnmmodules).\n")
-	_, _ = file.WriteString(strconv.Itoa(p.imageWidth))
-	_, _ = file.WriteString(" ")
-	_, _ = file.WriteString(strconv.Itoa(p.imageHeight))
-	_, _ = file.WriteString("\n")
-	_, _ = file.WriteString(strconv.Itoa(255))
-	_, _ = file.WriteString("\n")
+	w := bufio.NewWriter(p.metrics.countWrites(file))
+	check(encodePgm(p, w, world))
+	check(w.Flush())
 
-	world := make([][]byte, p.imageHeight)
-	for i := range world {
-		world[i] = make([]byte, p.imageWidth)
-	}
+	ioError = file.Sync()
+	check(ioError)
 
-	for y := 0; y < p.imageHeight; y++ {
-		for x := 0; x < p.imageWidth; x++ {
-			world[y][x] = <-i.distributor.worldState
-		}
+	p.println("File", filename, "output done!")
+	return path
+}
+
+// encodePgm writes world to w as a PGM image.
+func encodePgm(p golParams, w io.Writer, world [][]byte) error {
+	header := "P5\n" + strconv.Itoa(p.imageWidth) + " " + strconv.Itoa(p.imageHeight) + "\n" + strconv.Itoa(255) + "\n"
+	//header += "# PGM file writer by pnmmodules (https://github.com/owainkenwayucl/pnmmodules).\n"
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
 	}
 
 	for y := 0; y < p.imageHeight; y++ {
-		for x := 0; x < p.imageWidth; x++ {
-			_, ioError = file.Write([]byte{world[y][x]})
-			check(ioError)
+		if _, err := w.Write(world[y]); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	ioError = file.Sync()
-	check(ioError)
-
-	fmt.Println("File", filename, "output done!")
+// writeImage writes world in the format selected by p and returns the path written.
+func writeImage(p golParams, filename string, world [][]byte) string {
+	if p.image.format == formatPNG {
+		return writePng(p, filename, world)
+	}
+	return writePgm(p, filename, world)
 }
 
 // readPgmImage opens a pgm file and sends its data as an array of bytes.
 func readPgmImage(p golParams, i ioChans) {
 	filename := <-i.distributor.filename
-	data, ioError := ioutil.ReadFile("images/" + filename + ".pgm")
+	data, ioError := ioutil.ReadFile(filename)
 	check(ioError)
 
 	fields := strings.Fields(string(data))
@@ -85,24 +90,80 @@ func readPgmImage(p golParams, i ioChans) {
 
 	image := []byte(fields[4])
 
-	for _, b := range image {
-		i.distributor.inputVal <- b
+	world := make([][]byte, p.imageHeight)
+	for y := range world {
+		world[y] = image[y*p.imageWidth : (y+1)*p.imageWidth]
 	}
+	sendWorld(p, i, world)
 
-	fmt.Println("File", filename, "input done!")
+	p.println("File", filename, "input done!")
 }
 
+// sendWorld stamps the patterns selected in p onto world and sends it as an array of bytes.
+func sendWorld(p golParams, i ioChans, world [][]byte) {
+	stampPatterns(p, world)
+
+	for y := range world {
+		for _, b := range world[y] {
+			i.distributor.inputVal <- b
+		}
+	}
+}
+
+// ioBuffers is the number of copies of the world that can be waiting to be written at once.
+// Tests raise it so that snapshots are never skipped.
+var ioBuffers = 2
+
+// ioImage is a copy of the world handed to the io goroutine to be written as an image,
+// so that the distributor can carry on with the next turn.
+// The io goroutine owns world until it hands it back on the buffers channel.
+type ioImage struct {
+	name     string
+	world    [][]byte
+	snapshot bool
+}
+
+// writeIoImage writes img and hands its buffer back to the distributor.
+func writeIoImage(p golParams, i ioChans, sw *snapshotWriter, img ioImage) {
+	start := time.Now()
+	path := writeImage(p, img.name, img.world)
+	if img.snapshot {
+		if p.metrics != nil {
+			p.metrics.snapshotWritten(time.Since(start))
+		}
+		sw.retain(path)
+	}
+	i.distributor.buffers <- img.world
+}
+
+// pgmIo handles requests from the distributor until it is told to quit.
+// Images are written whenever the distributor hands one over.
 func pgmIo(p golParams, i ioChans) {
+	sw := snapshotWriter{p: p}
 	for {
 		select {
+		case img := <-i.distributor.images:
+			writeIoImage(p, i, &sw, img)
+
 		case command := <-i.distributor.command:
 			switch command {
 			case ioInput:
 				readPgmImage(p, i)
-			case ioOutput:
-				writePgmImage(p, i)
 			case ioCheckIdle:
+				// Finish every image handed over before the check
+				for idle := false; !idle; {
+					select {
+					case img := <-i.distributor.images:
+						writeIoImage(p, i, &sw, img)
+					default:
+						idle = true
+					}
+				}
 				i.distributor.idle <- true
+			case ioGenerate:
+				generateWorld(p, i)
+			case ioQuit:
+				return
 			}
 		}
 	}
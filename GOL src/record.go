@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// recordMaxBytes caps the memory used by the frames of a recording, which are kept until the end of the run.
+const recordMaxBytes = 256 << 20
+
+// recordParams describes an animated GIF recording of a run.
+// Frames are kept in memory until the end of the run. Once they would take more than recordMaxBytes, every other
+// frame is dropped and every is doubled, so a long run is recorded at a lower frame rate instead.
+type recordParams struct {
+	file  string
+	every int // Record one frame every this many turns
+	scale int // Width and height in pixels of each cell
+	delay int // Delay between frames in 100ths of a second
+
+	alive, dead color.Color
+
+	// Region of the world to record. The whole world is recorded if crop is empty.
+	crop image.Rectangle
+}
+
+// enabled reports whether the run should be recorded.
+func (r recordParams) enabled() bool {
+	return r.file != ""
+}
+
+// parseColour converts a colour such as "#ff8000" into a colour.
+func parseColour(s string) (color.Color, error) {
+	s = strings.TrimPrefix(s, "#")
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil || len(s) != 6 {
+		return nil, fmt.Errorf("invalid colour %q, expected e.g. #ff8000", s)
+	}
+	return color.RGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 0xFF}, nil
+}
+
+// parseRegion converts a region such as "10,10,64,32" (x, y, width, height) into a rectangle.
+// The width and height must be positive.
+func parseRegion(s string) (image.Rectangle, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return image.Rectangle{}, fmt.Errorf("invalid region %q, expected x,y,width,height", s)
+	}
+	var v [4]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil {
+			return image.Rectangle{}, fmt.Errorf("invalid region %q, expected x,y,width,height", s)
+		}
+		v[i] = n
+	}
+	if v[2] <= 0 || v[3] <= 0 {
+		return image.Rectangle{}, fmt.Errorf("invalid region %q, the width and height must be positive", s)
+	}
+	return image.Rect(v[0], v[1], v[0]+v[2], v[1]+v[3]), nil
+}
+
+// checkCrop returns an error if the region to record is set but doesn't overlap a world of the given size.
+func (r recordParams) checkCrop(width, height int) error {
+	if r.crop.Empty() || r.crop.Overlaps(image.Rect(0, 0, width, height)) {
+		return nil
+	}
+	return fmt.Errorf("the region to record %v lies outside the %dx%d world", r.crop, width, height)
+}
+
+// recorder collects frames from the distributor and writes them as an animated GIF.
+type recorder struct {
+	r        recordParams
+	region   image.Rectangle
+	palette  color.Palette
+	anim     gif.GIF
+	lastTurn int
+
+	// Number of frames that fit in recordMaxBytes, rounded down to an even number so that halving them
+	// leaves frames every 2*every turns from turn 0.
+	maxFrames int
+}
+
+func newRecorder(p golParams) *recorder {
+	rec := &recorder{r: p.record, lastTurn: -1}
+
+	if rec.r.every <= 0 {
+		rec.r.every = 1
+	}
+	if rec.r.scale <= 0 {
+		rec.r.scale = 1
+	}
+	if rec.r.alive == nil {
+		rec.r.alive = color.White
+	}
+	if rec.r.dead == nil {
+		rec.r.dead = color.Black
+	}
+	rec.palette = color.Palette{rec.r.dead, rec.r.alive}
+
+	rec.region = image.Rect(0, 0, p.imageWidth, p.imageHeight)
+	if !rec.r.crop.Empty() {
+		rec.region = rec.r.crop.Intersect(rec.region)
+	}
+
+	frameBytes := rec.region.Dx() * rec.r.scale * rec.region.Dy() * rec.r.scale
+	rec.maxFrames = 2
+	if frameBytes > 0 && recordMaxBytes/frameBytes > rec.maxFrames {
+		rec.maxFrames = recordMaxBytes / frameBytes &^ 1
+	}
+
+	return rec
+}
+
+// wants reports whether a frame should be recorded after the given turn.
+func (rec *recorder) wants(turn int) bool {
+	return turn%rec.r.every == 0 && turn != rec.lastTurn
+}
+
+// capture adds the recorded region of world as a frame.
+// If there are already maxFrames frames, every other frame is dropped first and frames are recorded half as often.
+func (rec *recorder) capture(turn int, world [][]byte) {
+	if len(rec.anim.Image) >= rec.maxFrames {
+		rec.thin()
+	}
+
+	s := rec.r.scale
+	frame := image.NewPaletted(image.Rect(0, 0, rec.region.Dx()*s, rec.region.Dy()*s), rec.palette)
+	for y := rec.region.Min.Y; y < rec.region.Max.Y; y++ {
+		for x := rec.region.Min.X; x < rec.region.Max.X; x++ {
+			if world[y][x] == 0 {
+				continue
+			}
+			for i := 0; i < s; i++ {
+				row := frame.Pix[((y-rec.region.Min.Y)*s+i)*frame.Stride:]
+				for j := 0; j < s; j++ {
+					row[(x-rec.region.Min.X)*s+j] = 1
+				}
+			}
+		}
+	}
+
+	rec.anim.Image = append(rec.anim.Image, frame)
+	rec.anim.Delay = append(rec.anim.Delay, rec.r.delay)
+	rec.lastTurn = turn
+}
+
+// thin drops every other frame, keeping the first, and doubles every.
+func (rec *recorder) thin() {
+	kept := 0
+	for i := 0; i < len(rec.anim.Image); i += 2 {
+		rec.anim.Image[kept] = rec.anim.Image[i]
+		rec.anim.Delay[kept] = rec.anim.Delay[i]
+		kept++
+	}
+	for i := kept; i < len(rec.anim.Image); i++ {
+		rec.anim.Image[i] = nil
+	}
+	rec.anim.Image = rec.anim.Image[:kept]
+	rec.anim.Delay = rec.anim.Delay[:kept]
+	rec.r.every *= 2
+}
+
+// close writes the recorded frames to the GIF file.
+func (rec *recorder) close(p golParams) {
+	file, ioError := os.Create(rec.r.file)
+	check(ioError)
+	defer file.Close()
+
+	check(gif.EncodeAll(file, &rec.anim))
+	p.println("Recording", rec.r.file, "with", len(rec.anim.Image), "frames done!")
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// reportSink selects where the distributor delivers alive-count reports.
+// Sinks are bit flags so several can be selected at once.
+type reportSink uint8
+
+const (
+	reportStdout reportSink = 1 << iota
+	reportCSV
+	reportEvents
+)
+
+// report is a snapshot of the population taken by the distributor.
+// births and deaths are counted since the previous report.
+type report struct {
+	turn      int
+	wallclock time.Time
+	alive     int
+	births    int
+	deaths    int
+}
+
+// parseReportSinks converts a comma separated list such as "stdout,csv" into a reportSink.
+func parseReportSinks(s string) (reportSink, error) {
+	var sinks reportSink
+	for _, name := range strings.Split(s, ",") {
+		switch strings.TrimSpace(strings.ToLower(name)) {
+		case "":
+		case "none":
+		case "stdout":
+			sinks |= reportStdout
+		case "csv":
+			sinks |= reportCSV
+		case "events":
+			sinks |= reportEvents
+		default:
+			return 0, fmt.Errorf("unknown report sink %q", name)
+		}
+	}
+	return sinks, nil
+}
+
+// reporter delivers reports to every sink selected in golParams.
+type reporter struct {
+	sinks  reportSink
+	file   *os.File
+	csv    *csv.Writer
+	events chan<- report
+}
+
+// newReporter opens the sinks selected in p. The CSV file, if any, is truncated.
+func newReporter(p golParams) *reporter {
+	r := &reporter{sinks: p.reportSinks, events: p.events}
+
+	if r.sinks&reportCSV != 0 {
+		file, ioError := os.Create(p.reportFile)
+		check(ioError)
+		r.file = file
+		r.csv = csv.NewWriter(file)
+		check(r.csv.Write([]string{"turn", "wallclock", "alive", "births", "deaths"}))
+	}
+
+	return r
+}
+
+// enabled reports whether the distributor needs to collect reports at all.
+func (r *reporter) enabled() bool {
+	return r.sinks != 0
+}
+
+// send delivers rep to all selected sinks.
+// Reports are dropped from the event channel if the receiver isn't ready, so that the game never waits for it.
+func (r *reporter) send(rep report) {
+	if r.sinks&reportStdout != 0 {
+		fmt.Println("No. of alive cells: ", rep.alive)
+	}
+
+	if r.sinks&reportCSV != 0 {
+		check(r.csv.Write([]string{
+			strconv.Itoa(rep.turn),
+			rep.wallclock.Format(time.RFC3339Nano),
+			strconv.Itoa(rep.alive),
+			strconv.Itoa(rep.births),
+			strconv.Itoa(rep.deaths),
+		}))
+		r.csv.Flush()
+	}
+
+	if r.sinks&reportEvents != 0 && r.events != nil {
+		select {
+		case r.events <- rep:
+		default:
+		}
+	}
+}
+
+// close flushes and closes the CSV file.
+func (r *reporter) close() {
+	if r.file != nil {
+		r.csv.Flush()
+		check(r.csv.Error())
+		check(r.file.Close())
+	}
+}
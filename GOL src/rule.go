@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// rule is a life-like rule. Bit n of birth is set if a dead cell with n alive neighbours is born,
+// and bit n of survive is set if an alive cell with n alive neighbours stays alive.
+type rule struct {
+	birth   uint16
+	survive uint16
+}
+
+// conway is the rule of Conway's Game of Life, B3/S23. It is used when golParams doesn't set a rule.
+var conway = rule{birth: 1 << 3, survive: 1<<2 | 1<<3}
+
+// born reports whether a dead cell with n alive neighbours is born.
+func (r rule) born(n int) bool {
+	return r.birth&(1<<uint(n)) != 0
+}
+
+// survives reports whether an alive cell with n alive neighbours stays alive.
+func (r rule) survives(n int) bool {
+	return r.survive&(1<<uint(n)) != 0
+}
+
+// String returns the rule in B/S notation, e.g. B3/S23.
+func (r rule) String() string {
+	var b strings.Builder
+	b.WriteString("B")
+	for n := 0; n <= 8; n++ {
+		if r.born(n) {
+			b.WriteByte(byte('0' + n))
+		}
+	}
+	b.WriteString("/S")
+	for n := 0; n <= 8; n++ {
+		if r.survives(n) {
+			b.WriteByte(byte('0' + n))
+		}
+	}
+	return b.String()
+}
+
+// parseRule converts a rule in B/S notation, such as B36/S23, into a rule.
+// The older S/B notation, such as 23/36, is also accepted.
+func parseRule(s string) (rule, error) {
+	parts := strings.Split(strings.ToUpper(strings.TrimSpace(s)), "/")
+	if len(parts) != 2 {
+		return rule{}, fmt.Errorf("invalid rule %q, expected e.g. B3/S23", s)
+	}
+
+	// S23/B3 is B3/S23 the other way round, and 23/3 is S/B notation without the letters
+	birth, survive := parts[0], parts[1]
+	if strings.HasPrefix(birth, "S") && strings.HasPrefix(survive, "B") {
+		birth, survive = survive, birth
+	} else if !strings.HasPrefix(birth, "B") && !strings.HasPrefix(survive, "S") {
+		birth, survive = "B"+survive, "S"+birth
+	}
+	if !strings.HasPrefix(birth, "B") || !strings.HasPrefix(survive, "S") {
+		return rule{}, fmt.Errorf("invalid rule %q, expected e.g. B3/S23", s)
+	}
+
+	var r rule
+	for _, ch := range birth[1:] {
+		if ch < '0' || ch > '8' {
+			return rule{}, fmt.Errorf("invalid rule %q, neighbour counts must be 0 to 8", s)
+		}
+		r.birth |= 1 << uint(ch-'0')
+	}
+	for _, ch := range survive[1:] {
+		if ch < '0' || ch > '8' {
+			return rule{}, fmt.Errorf("invalid rule %q, neighbour counts must be 0 to 8", s)
+		}
+		r.survive |= 1 << uint(ch-'0')
+	}
+	return r, nil
+}
+
+// topology describes what lies beyond the edges of the world.
+type topology uint8
+
+const (
+	torus    topology = iota // Both edges wrap around
+	cylinder                 // The left and right edges wrap around, cells above and below the world are dead
+	plane                    // Cells outside the world are dead
+)
+
+var topologyNames = []string{"torus", "cylinder", "plane"}
+
+func (t topology) String() string {
+	return topologyNames[t]
+}
+
+// wrapsX reports whether the left and right edges of the world wrap around.
+func (t topology) wrapsX() bool {
+	return t != plane
+}
+
+// wrapsY reports whether the top and bottom edges of the world wrap around.
+func (t topology) wrapsY() bool {
+	return t == torus
+}
+
+// wrap returns the cell that c lies on in a world of the given size, going around the edges that wrap.
+// Cells beyond an edge that doesn't wrap are returned unchanged, outside the world.
+func (t topology) wrap(c cell, width, height int) cell {
+	if t.wrapsX() {
+		c.x = (c.x%width + width) % width
+	}
+	if t.wrapsY() {
+		c.y = (c.y%height + height) % height
+	}
+	return c
+}
+
+// parseTopology converts a name such as "plane" into a topology.
+func parseTopology(name string) (topology, error) {
+	for i, n := range topologyNames {
+		if strings.EqualFold(n, name) {
+			return topology(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown topology %q, expected one of %s", name, strings.Join(topologyNames, ", "))
+}
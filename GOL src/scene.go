@@ -0,0 +1,109 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// scene is an initial world described in a JSON file, for example:
+//
+//	{
+//		"width": 64,
+//		"height": 64,
+//		"rule": "B3/S23",
+//		"topology": "torus",
+//		"patterns": [
+//			{"name": "glider", "x": 10, "y": 10},
+//			{"name": "glider", "x": 30, "y": 10, "reflect": true, "phase": 2},
+//			{"rle": "b2o$2o$bo!", "x": 40, "y": 40, "rotate": 1}
+//		]
+//	}
+//
+// rule and topology are optional and default to B3/S23 on a torus.
+type scene struct {
+	Width    int            `json:"width"`
+	Height   int            `json:"height"`
+	Rule     string         `json:"rule"`
+	Topology string         `json:"topology"`
+	Patterns []scenePattern `json:"patterns"`
+}
+
+// scenePattern places one pattern in a scene. See placement for the meaning of each field.
+type scenePattern struct {
+	Name    string `json:"name"`
+	RLE     string `json:"rle"`
+	X       int    `json:"x"`
+	Y       int    `json:"y"`
+	Rotate  int    `json:"rotate"`
+	Reflect bool   `json:"reflect"`
+	Phase   int    `json:"phase"`
+}
+
+// loadScene reads and checks a scene file.
+func loadScene(filename string) (scene, error) {
+	var s scene
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return s, err
+	}
+	if err = json.Unmarshal(data, &s); err != nil {
+		return s, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	if s.Width <= 0 || s.Height <= 0 {
+		return s, fmt.Errorf("%s: width and height must be positive", filename)
+	}
+	for i, sp := range s.Patterns {
+		if (sp.Name == "") == (sp.RLE == "") {
+			return s, fmt.Errorf("%s: pattern %d needs exactly one of name or rle", filename, i)
+		}
+		if sp.Phase < 0 {
+			return s, fmt.Errorf("%s: pattern %d has a negative phase", filename, i)
+		}
+		if sp.Name != "" {
+			if _, err = lookupPattern(sp.Name); err != nil {
+				return s, fmt.Errorf("%s: pattern %d: %v", filename, i, err)
+			}
+		} else if _, err = parseRLE(sp.RLE); err != nil {
+			return s, fmt.Errorf("%s: pattern %d: %v", filename, i, err)
+		}
+	}
+	return s, nil
+}
+
+// apply sets up p to start from the scene: an empty world of the scene's size, rule and topology
+// with the scene's patterns stamped onto it.
+func (s scene) apply(p *golParams) error {
+	p.imageWidth = s.Width
+	p.imageHeight = s.Height
+	p.emptyWorld = true
+
+	if s.Rule != "" {
+		r, err := parseRule(s.Rule)
+		if err != nil {
+			return err
+		}
+		p.rule = r
+	}
+	if s.Topology != "" {
+		t, err := parseTopology(s.Topology)
+		if err != nil {
+			return err
+		}
+		p.topology = t
+	}
+
+	for _, sp := range s.Patterns {
+		p.patterns = append(p.patterns, placement{
+			name:    sp.Name,
+			x:       sp.X,
+			y:       sp.Y,
+			rle:     sp.RLE,
+			phase:   sp.Phase,
+			reflect: sp.Reflect,
+			rotate:  sp.Rotate,
+		})
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// searchResult is the outcome of running one soup in a search.
+type searchResult struct {
+	seed   int64
+	turns  int
+	period int // 0 if the soup didn't stabilise within the turn limit
+	alive  int
+	census []censusEntry
+}
+
+// line formats r as a tab separated line for the results file.
+func (r searchResult) line() string {
+	// Unknown objects are listed by their code, so different unknown objects with the same code are merged
+	var names []string
+	counts := make(map[string]int)
+	for _, e := range r.census {
+		name := e.name
+		if name == "" {
+			name = e.code
+		}
+		name = strings.Replace(name, " ", "_", -1)
+		if counts[name] == 0 {
+			names = append(names, name)
+		}
+		counts[name] += e.count
+	}
+
+	var objects []string
+	for _, name := range names {
+		objects = append(objects, name+":"+strconv.Itoa(counts[name]))
+	}
+	return strings.Join([]string{
+		strconv.FormatInt(r.seed, 10),
+		strconv.Itoa(r.turns),
+		strconv.Itoa(r.period),
+		strconv.Itoa(r.alive),
+		strings.Join(objects, " "),
+	}, "\t")
+}
+
+// runSoup runs the soup with the given seed until it stabilises or p.turns is reached and takes a census of it.
+// Soups run on a plane, so that escaping gliders turn into ash at the edges instead of wrapping around
+// and crashing into what they left behind.
+func runSoup(p golParams, seed int64) searchResult {
+	cycles := make(chan cycle, 1)
+	p.soup.seed = seed
+	p.topology = plane
+	p.detectCycles = true
+	p.stopOnCycle = true
+	p.cycles = cycles
+
+	alive := gameOfLife(p, nil)
+
+	r := searchResult{seed: seed, turns: p.turns, alive: len(alive)}
+	select {
+	case c := <-cycles:
+		r.turns = c.turn + c.period
+		r.period = c.period
+	default:
+	}
+	r.census = takeCensus(p, alive)
+	return r
+}
+
+// runSearch is the search subcommand. It runs many seeded soups in parallel, each on as few workers as possible,
+// and appends a census of each to a results file.
+//noinspection GoUnhandledErrorResult
+func runSearch(args []string) {
+	fs := flag.NewFlagSet("search", flag.ExitOnError)
+
+	p := golParams{threads: 1, rule: conway, quiet: true, noFinalImage: true}
+	p.soup.generate = true
+	p.soup.width, p.soup.height = 16, 16
+
+	fs.IntVar(&p.turns, "turns", 10000, "Specify the turn limit for each soup. Defaults to 10000.")
+	fs.IntVar(&p.imageWidth, "w", 128, "Specify the width of the world. Defaults to 128.")
+	fs.IntVar(&p.imageHeight, "h", 128, "Specify the height of the world. Defaults to 128.")
+	fs.IntVar(&p.maxPeriod, "max-period", 64, "Specify the longest period counted as stable. Defaults to 64.")
+	fs.Float64Var(&p.soup.density, "soup-density", 0.5, "Specify the density of the soups. Defaults to 0.5.")
+	soupSize := fs.String("soup-size", "16x16", "Specify the size of the soups. Defaults to 16x16.")
+	soupSymmetry := fs.String("soup-symmetry", "C1", "Specify the symmetry of the soups. Defaults to C1.")
+	parallel := fs.Int("parallel", runtime.NumCPU(), "Specify how many soups to run at once. Defaults to the number of CPUs.")
+	soups := fs.Int64("soups", 0, "Specify how many soups to run. Defaults to 0, which runs until interrupted.")
+	seed := fs.Int64("seed", 0, "Specify the seed of the first soup. Defaults to a random seed.")
+	results := fs.String("results", "search.txt", "Specify the file that results are appended to. Defaults to search.txt.")
+	_ = fs.Parse(args)
+
+	var err error
+	p.soup.width, p.soup.height, err = parseSoupSize(*soupSize)
+	if err == nil {
+		p.soup.symmetry, err = parseSymmetry(*soupSymmetry)
+	}
+	if err == nil {
+		err = p.soup.checkDensity()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	file, ioError := os.OpenFile(*results, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	check(ioError)
+	defer file.Close()
+
+	fmt.Println("Searching from seed", *seed, "with", *parallel, "soups at once, results in", *results)
+
+	// Stop handing out seeds when interrupted, and print the totals once the soups already started have finished.
+	// A second interrupt exits straight away.
+	stop := make(chan struct{})
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		fmt.Println("Interrupted, finishing the soups already started")
+		close(stop)
+	}()
+
+	found := make(chan searchResult)
+	go searchSoups(p, *seed, *soups, *parallel, stop, found)
+
+	// Write results as they arrive and keep a running total of every object
+	totals := make(searchTotals)
+	searched := 0
+	start := time.Now()
+	for r := range found {
+		_, ioError = fmt.Fprintln(file, r.line())
+		check(ioError)
+		totals.add(r)
+
+		searched++
+		if searched%100 == 0 {
+			fmt.Printf("Searched %d soups, %.1f soups/s\n", searched, float64(searched)/time.Since(start).Seconds())
+		}
+	}
+
+	fmt.Println("Searched", searched, "soups")
+	printCensus(os.Stdout, totals.entries())
+}
+
+// searchSoups runs count soups with seeds from first on parallel searchers, and sends their results on found
+// in the order they finish. A count of 0 runs soups until stop is closed, and closing stop early stops handing out
+// seeds. found is closed once every soup that was started has finished.
+func searchSoups(p golParams, first, count int64, parallel int, stop <-chan struct{}, found chan<- searchResult) {
+	seeds := make(chan int64)
+	go func() {
+		defer close(seeds)
+		for s := first; count == 0 || s < first+count; s++ {
+			// 0 would be replaced by a random seed
+			if s == 0 {
+				continue
+			}
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			select {
+			case seeds <- s:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	wg.Add(parallel)
+	for i := 0; i < parallel; i++ {
+		go func() {
+			defer wg.Done()
+			for s := range seeds {
+				found <- runSoup(p, s)
+			}
+		}()
+	}
+	wg.Wait()
+	close(found)
+}
+
+// searchTotals is the running total of every object found in a search, keyed by code and name.
+type searchTotals map[string]*censusEntry
+
+// add adds the census of r to the totals.
+func (totals searchTotals) add(r searchResult) {
+	for _, e := range r.census {
+		key := e.code + "_" + e.name
+		if totals[key] == nil {
+			totals[key] = &censusEntry{code: e.code, name: e.name}
+		}
+		totals[key].count += e.count
+	}
+}
+
+// entries returns the totals with the most common objects first.
+func (totals searchTotals) entries() []censusEntry {
+	entries := make([]censusEntry, 0, len(totals))
+	for _, e := range totals {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].count != entries[j].count {
+			return entries[i].count > entries[j].count
+		}
+		return entries[i].code+entries[i].name < entries[j].code+entries[j].name
+	})
+	return entries
+}
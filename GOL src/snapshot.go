@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// snapshotParams describes images of the world taken automatically during a run.
+type snapshotParams struct {
+	// A snapshot is taken every turns turns, or every interval if turns is 0.
+	turns    int
+	interval time.Duration
+
+	// Only the last keep snapshots are kept on disk, or all of them if keep is 0.
+	keep int
+}
+
+// enabled reports whether snapshots should be taken.
+func (s snapshotParams) enabled() bool {
+	return s.turns > 0 || s.interval > 0
+}
+
+// parseSnapshotEvery converts a number of turns such as "1000", or a duration such as "30s",
+// into snapshotParams.
+func parseSnapshotEvery(s string) (snapshotParams, error) {
+	if n, err := strconv.Atoi(s); err == nil && n >= 0 {
+		return snapshotParams{turns: n}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return snapshotParams{interval: d}, nil
+	}
+	return snapshotParams{}, fmt.Errorf("invalid snapshot interval %q, expected a number of turns or a duration such as 30s", s)
+}
+
+// snapshotName returns the name of the snapshot image taken after the given turn.
+func (p golParams) snapshotName(turns int) string {
+	return p.outputName(turns) + "-snapshot"
+}
+
+// snapshotWriter removes old snapshots for the io goroutine.
+type snapshotWriter struct {
+	p       golParams
+	written []string
+}
+
+// retain records the snapshot written to path, then removes the oldest snapshots beyond the number to keep.
+func (sw *snapshotWriter) retain(path string) {
+	sw.written = append(sw.written, path)
+
+	keep := sw.p.snapshots.keep
+	for keep > 0 && len(sw.written) > keep {
+		_ = os.Remove(sw.written[0])
+		sw.written = sw.written[1:]
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// soupSymmetry is the symmetry imposed on a random soup.
+type soupSymmetry uint8
+
+const (
+	symmetryC1 soupSymmetry = iota // No symmetry
+	symmetryC2                     // 180 degree rotation
+	symmetryC4                     // 90 degree rotation, needs a square soup
+	symmetryD8                     // All rotations and reflections, needs a square soup
+)
+
+var symmetryNames = []string{"C1", "C2", "C4", "D8"}
+
+func (s soupSymmetry) String() string {
+	return symmetryNames[s]
+}
+
+// parseSymmetry converts a name such as "C4" into a soupSymmetry.
+func parseSymmetry(name string) (soupSymmetry, error) {
+	for i, n := range symmetryNames {
+		if strings.EqualFold(n, name) {
+			return soupSymmetry(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown symmetry %q, expected one of %s", name, strings.Join(symmetryNames, ", "))
+}
+
+// soupParams describes a random soup placed in the middle of an otherwise empty world.
+// A width or height of 0 fills the whole world. A seed of 0 is replaced by a random seed when the game starts.
+type soupParams struct {
+	generate      bool
+	width, height int
+	density       float64
+	seed          int64
+	symmetry      soupSymmetry
+}
+
+// enabled reports whether a soup should be generated instead of loading a PGM image.
+// A soup with a density of 0 is still generated, and is empty.
+func (s soupParams) enabled() bool {
+	return s.generate
+}
+
+// checkDensity returns an error if the density of the soup isn't a fraction between 0 and 1.
+func (s soupParams) checkDensity() error {
+	if !(s.density >= 0 && s.density <= 1) {
+		return fmt.Errorf("invalid soup density %v, expected a fraction between 0 and 1", s.density)
+	}
+	return nil
+}
+
+// size returns the size of the soup in a world of the given size.
+func (s soupParams) size(p golParams) (int, int) {
+	w, h := s.width, s.height
+	if w <= 0 || w > p.imageWidth {
+		w = p.imageWidth
+	}
+	if h <= 0 || h > p.imageHeight {
+		h = p.imageHeight
+	}
+	if s.symmetry == symmetryC4 || s.symmetry == symmetryD8 {
+		if w < h {
+			h = w
+		} else {
+			w = h
+		}
+	}
+	return w, h
+}
+
+// parseSoupSize converts a size such as "16x16" or "16" into a width and height.
+func parseSoupSize(size string) (int, int, error) {
+	parts := strings.Split(size, "x")
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("invalid soup size %q", size)
+	}
+	w, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid soup size %q", size)
+	}
+	h, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid soup size %q", size)
+	}
+	return w, h, nil
+}
+
+// soupWorld generates the soup described by p.soup in a world of p.imageWidth x p.imageHeight.
+// The same parameters always generate the same world.
+func soupWorld(p golParams) [][]byte {
+	world := make([][]byte, p.imageHeight)
+	for i := range world {
+		world[i] = make([]byte, p.imageWidth)
+	}
+
+	w, h := p.soup.size(p)
+	x0, y0 := (p.imageWidth-w)/2, (p.imageHeight-h)/2
+	random := rand.New(rand.NewSource(p.soup.seed))
+	assigned := make([][]bool, h)
+	for i := range assigned {
+		assigned[i] = make([]bool, w)
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if assigned[y][x] {
+				continue
+			}
+
+			var val byte
+			if random.Float64() < p.soup.density {
+				val = 0xFF
+			}
+
+			// Give every cell that the symmetry maps this cell onto the same value
+			for _, c := range symmetricCells(p.soup.symmetry, x, y, w, h) {
+				assigned[c.y][c.x] = true
+				world[y0+c.y][x0+c.x] = val
+			}
+		}
+	}
+
+	return world
+}
+
+// symmetricCells returns the cells in a w x h soup that the symmetry maps x, y onto, including x, y itself.
+func symmetricCells(s soupSymmetry, x, y, w, h int) []cell {
+	cells := []cell{{x, y}}
+	switch s {
+	case symmetryC2:
+		cells = append(cells, cell{w - 1 - x, h - 1 - y})
+	case symmetryC4:
+		cells = append(cells, cell{w - 1 - y, x}, cell{w - 1 - x, h - 1 - y}, cell{y, h - 1 - x})
+	case symmetryD8:
+		cells = append(cells, cell{w - 1 - y, x}, cell{w - 1 - x, h - 1 - y}, cell{y, h - 1 - x},
+			cell{w - 1 - x, y}, cell{x, h - 1 - y}, cell{y, x}, cell{w - 1 - y, h - 1 - x})
+	}
+	return cells
+}
+
+// generateWorld generates the soup described by p.soup, or an empty world if there is no soup,
+// and sends it as an array of bytes.
+func generateWorld(p golParams, i ioChans) {
+	if !p.soup.enabled() {
+		world := make([][]byte, p.imageHeight)
+		for y := range world {
+			world[y] = make([]byte, p.imageWidth)
+		}
+		sendWorld(p, i, world)
+		return
+	}
+
+	sendWorld(p, i, soupWorld(p))
+
+	w, h := p.soup.size(p)
+	p.println("Soup", strconv.Itoa(w)+"x"+strconv.Itoa(h), p.soup.symmetry, "with density", p.soup.density,
+		"and seed", p.soup.seed, "generated!")
+}
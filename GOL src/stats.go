@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+)
+
+// stripStats is what a worker reports about its strip at the end of every turn.
+// The bounding box and coordinate sums are only filled in when golParams asks for population statistics.
+type stripStats struct {
+	alive  int
+	births int
+	deaths int
+
+	// Bounding box of the alive cells in world coordinates (inclusive).
+	minX, minY, maxX, maxY int
+
+	// Sums of the coordinates of the alive cells, used to calculate the centroid.
+	sumX, sumY int
+
+	// Hash of the strip, only filled in when golParams asks for cycle detection.
+	hash uint64
+
+	// Cells that flipped this turn in world coordinates, only filled in when golParams has a live view.
+	flips []cell
+}
+
+// measureStrip fills in the bounding box and coordinate sums of s from the strip.
+// offsetY is the world y coordinate of the first row of the strip.
+func (s *stripStats) measureStrip(source [][]byte, offsetY int) {
+	s.minX, s.minY, s.maxX, s.maxY = -1, -1, -1, -1
+	s.sumX, s.sumY = 0, 0
+
+	for y := range source {
+		for x, v := range source[y] {
+			if v == 0 {
+				continue
+			}
+			wy := y + offsetY
+			if s.minX < 0 || x < s.minX {
+				s.minX = x
+			}
+			if x > s.maxX {
+				s.maxX = x
+			}
+			if s.minY < 0 {
+				s.minY = wy
+			}
+			s.maxY = wy
+			s.sumX += x
+			s.sumY += wy
+		}
+	}
+}
+
+// turnStats describes the whole world after a turn.
+// It is assembled by the distributor from the stripStats of every worker.
+type turnStats struct {
+	turn   int
+	alive  int
+	births int
+	deaths int
+
+	// Bounding box of the alive cells (inclusive). All -1 if the world is empty.
+	minX, minY, maxX, maxY int
+
+	// Mean position of the alive cells. This is a plain mean, it doesn't account for the world wrapping around.
+	centroidX, centroidY float64
+
+	// Fraction of alive cells in each worker's strip, from the top of the world downwards.
+	stripDensity []float64
+}
+
+// density returns the fraction of the world that is alive.
+func (s turnStats) density(p golParams) float64 {
+	return float64(s.alive) / float64(p.imageWidth*p.imageHeight)
+}
+
+// combineStrips aggregates the stripStats of every worker into a turnStats.
+// yParams holds the first row of each strip, followed by the height of the world.
+func combineStrips(p golParams, turn int, strips []stripStats, yParams []int) turnStats {
+	ts := turnStats{turn: turn, minX: -1, minY: -1, maxX: -1, maxY: -1}
+	sumX, sumY := 0, 0
+
+	if p.wantsStats() {
+		ts.stripDensity = make([]float64, len(strips))
+	}
+
+	for i, s := range strips {
+		ts.alive += s.alive
+		ts.births += s.births
+		ts.deaths += s.deaths
+
+		if !p.wantsStats() {
+			continue
+		}
+
+		ts.stripDensity[i] = float64(s.alive) / float64((yParams[i+1]-yParams[i])*p.imageWidth)
+		if s.alive == 0 {
+			continue
+		}
+		if ts.minX < 0 || s.minX < ts.minX {
+			ts.minX = s.minX
+		}
+		if s.maxX > ts.maxX {
+			ts.maxX = s.maxX
+		}
+		if ts.minY < 0 {
+			ts.minY = s.minY
+		}
+		ts.maxY = s.maxY
+		sumX += s.sumX
+		sumY += s.sumY
+	}
+
+	if ts.alive > 0 {
+		ts.centroidX = float64(sumX) / float64(ts.alive)
+		ts.centroidY = float64(sumY) / float64(ts.alive)
+	}
+
+	return ts
+}
+
+// statsRecorder delivers turnStats to the stats channel and CSV file selected in golParams.
+type statsRecorder struct {
+	p     golParams
+	file  *os.File
+	csv   *csv.Writer
+	stats chan<- turnStats
+}
+
+// newStatsRecorder opens the CSV file selected in p, if any. The file is truncated.
+func newStatsRecorder(p golParams) *statsRecorder {
+	s := &statsRecorder{p: p, stats: p.stats}
+
+	if p.statsFile != "" {
+		file, ioError := os.Create(p.statsFile)
+		check(ioError)
+		s.file = file
+		s.csv = csv.NewWriter(file)
+
+		header := []string{"turn", "alive", "births", "deaths", "min_x", "min_y", "max_x", "max_y",
+			"centroid_x", "centroid_y", "density"}
+		for i := 0; i < p.threads; i++ {
+			header = append(header, "density_"+strconv.Itoa(i))
+		}
+		check(s.csv.Write(header))
+	}
+
+	return s
+}
+
+// record delivers ts to the stats channel, waiting for the receiver, and appends it to the CSV file.
+func (s *statsRecorder) record(ts turnStats) {
+	if s.stats != nil {
+		s.stats <- ts
+	}
+
+	if s.csv != nil {
+		row := []string{
+			strconv.Itoa(ts.turn),
+			strconv.Itoa(ts.alive),
+			strconv.Itoa(ts.births),
+			strconv.Itoa(ts.deaths),
+			strconv.Itoa(ts.minX),
+			strconv.Itoa(ts.minY),
+			strconv.Itoa(ts.maxX),
+			strconv.Itoa(ts.maxY),
+			strconv.FormatFloat(ts.centroidX, 'f', 3, 64),
+			strconv.FormatFloat(ts.centroidY, 'f', 3, 64),
+			strconv.FormatFloat(ts.density(s.p), 'f', 6, 64),
+		}
+		for _, d := range ts.stripDensity {
+			row = append(row, strconv.FormatFloat(d, 'f', 6, 64))
+		}
+		check(s.csv.Write(row))
+	}
+}
+
+// close flushes and closes the CSV file.
+func (s *statsRecorder) close() {
+	if s.file != nil {
+		s.csv.Flush()
+		check(s.csv.Error())
+		check(s.file.Close())
+	}
+}
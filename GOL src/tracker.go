@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// trackMaxPeriod is the longest period looked for by the tracker, for spaceships and for population growth.
+const trackMaxPeriod = 32
+
+// trackMinGrowthPeriod is the shortest period looked for in population growth, that of the fastest known guns.
+// Soups often grow by the same amount for a few turns in a row over shorter periods while they settle.
+const trackMinGrowthPeriod = 14
+
+// trackRepeats is how many periods in a row a spaceship has to move by the same amount, or the population
+// has to grow by the same amount, to be reported.
+const trackRepeats = 3
+
+// spaceship is a translating object found by the tracker.
+// It moves by dx, dy every period turns.
+type spaceship struct {
+	turn   int
+	name   string
+	period int
+	dx, dy int
+}
+
+// velocity returns the speed and direction of s in the usual notation, e.g. "c/4 diagonal".
+func (s spaceship) velocity() string {
+	adx, ady := abs(s.dx), abs(s.dy)
+	distance := adx
+	if ady > distance {
+		distance = ady
+	}
+
+	direction := "oblique"
+	if adx == 0 || ady == 0 {
+		direction = "orthogonal"
+	} else if adx == ady {
+		direction = "diagonal"
+	}
+
+	g := gcd(distance, s.period)
+	speed := "c"
+	if distance/g != 1 {
+		speed = strconv.Itoa(distance/g) + speed
+	}
+	if s.period/g != 1 {
+		speed += "/" + strconv.Itoa(s.period/g)
+	}
+	return speed + " " + direction
+}
+
+// emitter is a world whose population grows by growth cells every period turns, such as a gun or puffer.
+type emitter struct {
+	turn   int
+	period int
+	growth int
+}
+
+// tracker follows the objects in the world from turn to turn to find spaceships and emitters.
+type tracker struct {
+	width, height int
+	rule          rule
+	topology      topology
+
+	// frames[turn % len(frames)] maps the shape of every object after that turn to the top left corners
+	// of the objects with that shape.
+	frames []map[string][]cell
+
+	// population[turn % len(population)] is the number of alive cells after that turn.
+	population []int
+
+	// Canonical forms of every phase of the spaceships already reported, and the period of the emitter reported
+	// for as long as the population keeps growing with that period, or 0.
+	found    map[string]bool
+	emitting int
+}
+
+func newTracker(p golParams) *tracker {
+	return &tracker{
+		width:      p.imageWidth,
+		height:     p.imageHeight,
+		rule:       p.rule,
+		topology:   p.topology,
+		frames:     make([]map[string][]cell, trackMaxPeriod*trackRepeats+1),
+		population: make([]int, 2*trackMaxPeriod*trackRepeats+1),
+		found:      make(map[string]bool),
+	}
+}
+
+// wrap returns the shortest displacement equivalent to d in a world of the given size.
+func wrap(d, size int) int {
+	d = ((d % size) + size) % size
+	if d > size/2 {
+		d -= size
+	}
+	return d
+}
+
+// add records the alive cells after the given turn.
+// It returns spaceships and emitters that haven't been reported before. Turns must be added in order.
+func (t *tracker) add(turn int, alive []cell) ([]spaceship, []emitter) {
+	var ships []spaceship
+	var emitters []emitter
+
+	frame := make(map[string][]cell)
+	for _, object := range clusters(alive, t.width, t.height, t.topology) {
+		n, corner := normalise(object)
+		key := shape(n)
+		frame[key] = append(frame[key], corner)
+
+		if t.found[canonical(n)] {
+			continue
+		}
+
+		// Find the most recent turn in which an object of the same shape was nearby.
+		// Objects that come back to where they were are still lifes or oscillators.
+	periods:
+		for period := 1; period <= trackMaxPeriod && period*trackRepeats <= turn; period++ {
+			for _, previous := range t.frames[(turn-period)%len(t.frames)][key] {
+				dx, dy := corner.x-previous.x, corner.y-previous.y
+				if t.topology.wrapsX() {
+					dx = wrap(dx, t.width)
+				}
+				if t.topology.wrapsY() {
+					dy = wrap(dy, t.height)
+				}
+				if abs(dx) > period || abs(dy) > period {
+					continue
+				}
+				if dx == 0 && dy == 0 {
+					break periods
+				}
+				if !t.moved(turn, period, key, corner, dx, dy) {
+					continue
+				}
+
+				ships = append(ships, spaceship{
+					turn:   turn,
+					name:   knownName(n, t.rule),
+					period: period,
+					dx:     dx,
+					dy:     dy,
+				})
+
+				// Don't report the spaceship again in any of its phases
+				phase := n
+				for i := 0; i < period; i++ {
+					t.found[canonical(phase)] = true
+					phase = evolveRule(phase, t.rule)
+				}
+				break periods
+			}
+		}
+	}
+	t.frames[turn%len(t.frames)] = frame
+
+	// Once the population stops growing with the period of the emitter already reported, look for another one
+	t.population[turn%len(t.population)] = len(alive)
+	if t.emitting > 0 {
+		if _, linear := t.growth(turn, t.emitting); !linear {
+			t.emitting = 0
+		}
+	}
+	for period := trackMinGrowthPeriod; period <= trackMaxPeriod && t.emitting == 0; period++ {
+		if growth, linear := t.growth(turn, period); linear {
+			t.emitting = period
+			emitters = append(emitters, emitter{turn: turn, period: period, growth: growth})
+		}
+	}
+
+	return ships, emitters
+}
+
+// growth returns how much the population grew in the period turns up to turn, and whether it grew by that same
+// positive amount every period, measured from every turn in the last trackRepeats periods.
+func (t *tracker) growth(turn, period int) (int, bool) {
+	population := func(turn int) int {
+		return t.population[turn%len(t.population)]
+	}
+	if turn < 2*period*trackRepeats {
+		return 0, false
+	}
+	growth := population(turn) - population(turn-period)
+	if growth <= 0 {
+		return growth, false
+	}
+	for r := 1; r < period*trackRepeats; r++ {
+		if population(turn-r)-population(turn-r-period) != growth {
+			return growth, false
+		}
+	}
+	return growth, true
+}
+
+// moved reports whether an object with the given shape was displaced by dx, dy every period turns
+// for trackRepeats periods up to turn, ending at corner.
+func (t *tracker) moved(turn, period int, key string, corner cell, dx, dy int) bool {
+	for r := 1; r <= trackRepeats; r++ {
+		expected := t.topology.wrap(cell{corner.x - r*dx, corner.y - r*dy}, t.width, t.height)
+		found := false
+		for _, previous := range t.frames[(turn-r*period)%len(t.frames)][key] {
+			if t.topology.wrap(previous, t.width, t.height) == expected {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// printTracked prints the spaceships and emitters found by the tracker.
+func printTracked(ships []spaceship, emitters []emitter) {
+	for _, s := range ships {
+		name := s.name
+		if name == "" {
+			name = "unknown spaceship"
+		}
+		fmt.Println("Turn", s.turn, "found", name, "with period", s.period,
+			"moving", s.dx, s.dy, "at", s.velocity())
+	}
+	for _, e := range emitters {
+		fmt.Println("Turn", e.turn, "population grows by", e.growth, "every", e.period,
+			"turns, possible gun or puffer")
+	}
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
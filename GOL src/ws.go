@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// WebSocket opcodes from RFC 6455.
+const (
+	wsText   = 0x1
+	wsBinary = 0x2
+	wsClose  = 0x8
+	wsPing   = 0x9
+	wsPong   = 0xA
+)
+
+// wsMaxMessage is the largest message accepted from a browser. Browsers only send commands.
+const wsMaxMessage = 1 << 16
+
+// wsGUID is appended to the client's key to accept a WebSocket handshake.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// wsConn is the server side of a WebSocket connection.
+// Messages may be written from several goroutines, but only one goroutine may read.
+type wsConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+
+	writeLock sync.Mutex
+}
+
+// upgradeWebSocket completes the WebSocket handshake for r and takes over its connection.
+// Browsers let any page open a WebSocket to any host, so upgrades from pages served by another host,
+// whose Origin doesn't match the host they connect to, are refused. Clients other than browsers send no Origin.
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*wsConn, error) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return nil, errors.New("not a WebSocket upgrade")
+	}
+	if origin := r.Header.Get("Origin"); origin != "" {
+		u, err := url.Parse(origin)
+		if err != nil || !strings.EqualFold(u.Host, r.Host) {
+			http.Error(w, "cross origin WebSocket refused", http.StatusForbidden)
+			return nil, errors.New("cross origin WebSocket from " + origin)
+		}
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "WebSockets aren't supported", http.StatusInternalServerError)
+		return nil, errors.New("connection can't be hijacked")
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	accept := sha1.Sum([]byte(key + wsGUID))
+	_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(accept[:]) + "\r\n\r\n")
+	if err = rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &wsConn{conn: conn, r: rw.Reader}, nil
+}
+
+// writeMessage sends payload as a single unmasked frame.
+func (ws *wsConn) writeMessage(opcode byte, payload []byte) error {
+	ws.writeLock.Lock()
+	defer ws.writeLock.Unlock()
+
+	header := []byte{0x80 | opcode, 0}
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = append(header, 0, 0)
+		binary.BigEndian.PutUint16(header[2:], uint16(n))
+	default:
+		header[1] = 127
+		header = append(header, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(header[2:], uint64(n))
+	}
+	if _, err := ws.conn.Write(header); err != nil {
+		return err
+	}
+	_, err := ws.conn.Write(payload)
+	return err
+}
+
+// readMessage returns the next text or binary message, answering pings on the way.
+// It returns io.EOF once the browser closes the connection.
+func (ws *wsConn) readMessage() (byte, []byte, error) {
+	for {
+		var header [2]byte
+		if _, err := io.ReadFull(ws.r, header[:]); err != nil {
+			return 0, nil, err
+		}
+		fin, opcode := header[0]&0x80 != 0, header[0]&0x0F
+		masked, n := header[1]&0x80 != 0, uint64(header[1]&0x7F)
+
+		switch n {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(ws.r, ext[:]); err != nil {
+				return 0, nil, err
+			}
+			n = uint64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(ws.r, ext[:]); err != nil {
+				return 0, nil, err
+			}
+			n = binary.BigEndian.Uint64(ext[:])
+		}
+		// Browsers always mask their frames, and never need to split commands across frames
+		if !masked || !fin || n > wsMaxMessage {
+			return 0, nil, errors.New("unsupported WebSocket frame")
+		}
+
+		var mask [4]byte
+		if _, err := io.ReadFull(ws.r, mask[:]); err != nil {
+			return 0, nil, err
+		}
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(ws.r, payload); err != nil {
+			return 0, nil, err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch opcode {
+		case wsText, wsBinary:
+			return opcode, payload, nil
+		case wsPing:
+			if err := ws.writeMessage(wsPong, payload); err != nil {
+				return 0, nil, err
+			}
+		case wsClose:
+			_ = ws.writeMessage(wsClose, nil)
+			return 0, nil, io.EOF
+		}
+	}
+}
+
+// Close closes the underlying connection.
+func (ws *wsConn) Close() error {
+	return ws.conn.Close()
+}